@@ -0,0 +1,12 @@
+package notification
+
+// 通知地址, 每个地址独立发送和重试
+type Destination struct {
+	Channel     string `json:"channel"` // 发送通道, 见 channel.go, 默认为 CHANNEL_HTTP
+	Url         string `json:"url"`
+	Headers     string `json:"headers"`
+	Encoding    string `json:"encoding"`     // 请求体编码, 同 MessageMeta.Encoding
+	Checker     string `json:"checker"`      // 返回检查方式, 见 checker.go, 默认为 CHECKER_DEFAULT
+	MaxAttempts int32  `json:"max_attempts"` // 最多重试次数, 0 表示按全部重试间隔重试
+	Secret      string `json:"secret"`       // 签名密钥, 指定时请求带签名 header, 见 sign
+}
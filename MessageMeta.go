@@ -1,17 +1,67 @@
 package notification
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type MessageMeta struct {
-	Url         string `json:"url"`
-	Headers     string `json:"headers"`
-	Attempts    int    `json:"attempts"`
-	MaxAttempts int    `json:"max_attempts"`
+	Id          string   `json:"id"` // 消息 id, 作为状态事件的 key, 为空时为 topic-partition-offset
+	Url         string   `json:"url"`
+	Headers     string   `json:"headers"`
+	Attempts    int      `json:"attempts"`
+	MaxAttempts int      `json:"max_attempts"`
+	Encoding    string   `json:"encoding"`   // 请求体编码: json(默认), form, multipart, xml, text, binary
+	Channel     string   `json:"channel"`    // 发送通道: http(默认)
+	DeliverAt   int64    `json:"deliver_at"` // 首次发送时间(Unix 时间戳), 未到时间的消息放入延迟队列
+	Delay       int64    `json:"delay"`      // 首次发送延迟(秒), 从 kafka 消息时间开始计算, 同时指定 deliver_at 时以 deliver_at 为准
+	ExpiresAt   int64    `json:"expires_at"` // 过期时间(Unix 时间戳), 过期后不再发送和重试
+	Ttl         int64    `json:"ttl"`        // 有效期(秒), 从 kafka 消息时间开始计算, 同时指定 expires_at 时以 expires_at 为准
+	Timeouts    Timeouts `json:"timeouts"`   // 每次发送的超时时间(毫秒), 没有指定的使用按 host 配置的或默认的
+
+	Destinations []Destination `json:"destinations"` // 多个通知地址, 每个地址独立发送和重试, 指定时忽略 url, headers, encoding 和 channel
+	Event        string        `json:"event"`        // 事件类型, 指定时发送到订阅该事件的地址, 忽略 url 和 destinations
+	Tenant       string        `json:"tenant"`       // 租户, 与 event 一起匹配订阅
 
 	encoded []byte `json:"-"`
 	err     error  `json:"-"`
 }
 
+// 通知地址, 没有指定 destinations 时使用 url, headers, encoding 和 channel
+func (ale *MessageMeta) destinations() []Destination {
+	if len(ale.Destinations) > 0 {
+		return ale.Destinations
+	}
+	return []Destination{{Channel: ale.Channel, Url: ale.Url, Headers: ale.Headers, Encoding: ale.Encoding}}
+}
+
+// 首次发送时间(Unix 时间戳), 0 表示立即发送
+// produced 为 kafka 消息的时间, 旧版本 kafka 没有消息时间时从当前时间开始计算 delay
+func (ale *MessageMeta) deliverTime(produced time.Time) int64 {
+	if ale.DeliverAt > 0 {
+		return ale.DeliverAt
+	}
+	if ale.Delay > 0 {
+		if produced.IsZero() {
+			produced = time.Now()
+		}
+		return produced.Unix() + ale.Delay
+	}
+	return 0
+}
+
+// 过期时间(Unix 时间戳), 0 表示永不过期
+// 没有 kafka 消息时间时无法计算 ttl, 视为永不过期
+func (ale *MessageMeta) expireTime(produced time.Time) int64 {
+	if ale.ExpiresAt > 0 {
+		return ale.ExpiresAt
+	}
+	if ale.Ttl > 0 && !produced.IsZero() {
+		return produced.Unix() + ale.Ttl
+	}
+	return 0
+}
+
 func (ale *MessageMeta) ensureEncoded() {
 	if ale.encoded == nil && ale.err == nil {
 		ale.encoded, ale.err = json.Marshal(ale)
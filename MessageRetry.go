@@ -1,22 +1,100 @@
 package notification
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 const (
-	FORMAT_LIST = "%s-list-attempts-%d-%s"
-	FORMAT_HASH = "%s-hash-offset-%d"
+	FORMAT_LIST      = "%s-list-attempts-%d-%s"
+	FORMAT_HASH      = "%s-hash-offset-%d" // 旧格式, 只按 offset 区分, 兼容升级前已在重试中的消息
+	FORMAT_HASH_DEST = "%s-hash-%d-%d-%d"  // 按 partition, offset 和通知地址序号区分
+	FORMAT_DELAY     = "%s-zset-delayed"   // 延迟发送队列 (sorted set), score 为发送时间
+	FORMAT_DEAD      = "%s-list-dead"      // 死信列表, 原因记录在 hash 的 reason 字段
+	FORMAT_MEMBER    = "%d:%d:%d"          // redis 列表和延迟队列中的成员: partition:offset:destination
 )
 
 type MessageRetry struct {
-	Offset    int64 // 消息所在 offset
-	Partition int32 // 消息所在 partition
-	Attempts  int32 // 已尝试次数
-	NextTime  int64 // 下一次尝试时间(Unix 时间戳)
+	Offset       int64  // 消息所在 offset
+	Partition    int32  // 消息所在 partition
+	Destination  int32  // 通知地址在 MessageMeta.destinations() (或匹配的订阅) 中的序号
+	Subscription string // 订阅 id, 按事件发送时重试使用该订阅 (订阅可能已增删, 序号不再对应)
+	Attempts     int32  // 已尝试次数
+	NextTime     int64  // 下一次尝试时间(Unix 时间戳)
 }
 
 func (p *MessageRetry) Fields() map[string]interface{} {
 	return map[string]interface{}{
-		"offset":    p.Offset,
-		"partition": p.Partition,
-		"attempts":  p.Attempts,
-		"next_time": p.NextTime,
+		"offset":       p.Offset,
+		"partition":    p.Partition,
+		"destination":  p.Destination,
+		"subscription": p.Subscription,
+		"attempts":     p.Attempts,
+		"next_time":    p.NextTime,
+	}
+}
+
+// redis 列表和延迟队列中的成员
+func (p *MessageRetry) Member() string {
+	return fmt.Sprintf(FORMAT_MEMBER, p.Partition, p.Offset, p.Destination)
+}
+
+// 保存重试数据的 redis hash
+func (p *MessageRetry) HashKey(topic string) string {
+	return fmt.Sprintf(FORMAT_HASH_DEST, topic, p.Partition, p.Offset, p.Destination)
+}
+
+// 解析 redis 列表和延迟队列中的成员, 返回对应的 redis hash
+// 兼容旧格式 (只有 offset, 只有一个通知地址), 旧格式的 partition 需要从 hash 中读取
+func ParseMember(topic string, member string) (retryData MessageRetry, hashKey string, err error) {
+	parts := strings.Split(member, ":")
+	if len(parts) == 1 {
+		if retryData.Offset, err = strconv.ParseInt(member, 10, 64); err != nil {
+			return
+		}
+		hashKey = fmt.Sprintf(FORMAT_HASH, topic, retryData.Offset)
+		return
+	}
+	if len(parts) != 3 {
+		err = fmt.Errorf("invalid member: %s", member)
+		return
+	}
+
+	var partition, destination int64
+	if partition, err = strconv.ParseInt(parts[0], 10, 32); err != nil {
+		return
+	}
+	if retryData.Offset, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return
+	}
+	if destination, err = strconv.ParseInt(parts[2], 10, 32); err != nil {
+		return
+	}
+	retryData.Partition = int32(partition)
+	retryData.Destination = int32(destination)
+	hashKey = retryData.HashKey(topic)
+	return
+}
+
+// 各级重试列表, 与 getNextTime 的间隔一一对应, Attempts 为 n 的消息位于第 n-1 个列表
+func RetryLists(topic string) []string {
+	return []string{
+		fmt.Sprintf(FORMAT_LIST, topic, 2, "4m"),
+		fmt.Sprintf(FORMAT_LIST, topic, 3, "10m"),
+		fmt.Sprintf(FORMAT_LIST, topic, 4, "10m"),
+		fmt.Sprintf(FORMAT_LIST, topic, 5, "1h"),
+		fmt.Sprintf(FORMAT_LIST, topic, 6, "2h"),
+		fmt.Sprintf(FORMAT_LIST, topic, 7, "6h"),
+		fmt.Sprintf(FORMAT_LIST, topic, 8, "15h"),
+	}
+}
+
+// Attempts 为 attempts 的消息再次失败时进入的重试列表, 空字符串表示已达到重试上限
+func NextRetryList(topic string, attempts int32) string {
+	lists := RetryLists(topic)
+	if attempts < 0 || int(attempts) >= len(lists) {
+		return ""
 	}
+	return lists[attempts]
 }
@@ -0,0 +1,362 @@
+package notification
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	HEADER_API_KEY = "X-Api-Key" // 接入接口和管理接口的 api key, 也可以使用 Authorization: Bearer
+
+	REDACTED_SECRET = "******" // 管理接口返回的订阅中替代 secret, 修改订阅时原样提交表示不修改 secret
+)
+
+// 管理接口的 api key, 为空时只有订阅接口不可用, 由启动程序根据配置设置
+var adminKeys []string
+
+func SetAdminKeys(keys []string) {
+	adminKeys = keys
+}
+
+// 管理接口中的一条记录 (延迟, 重试或死信)
+type PendingEntry struct {
+	List         string `json:"list"`
+	Member       string `json:"member"`
+	Offset       int64  `json:"offset"`
+	Partition    int32  `json:"partition"`
+	Destination  int32  `json:"destination"`
+	Subscription string `json:"subscription,omitempty"`
+	Attempts     int32  `json:"attempts"`
+	NextTime     int64  `json:"next_time"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// 管理接口
+//
+// GET    /admin/delayed           查看延迟队列
+// DELETE /admin/delayed?member=M  取消延迟发送, M 为 partition:offset:destination (旧格式为 offset)
+// GET    /admin/retries           查看各级重试列表
+// DELETE /admin/retries?member=M  取消重试
+// GET    /admin/dead              查看死信列表
+// GET    /admin/breakers          查看各 host 的熔断状态 (本进程)
+// DELETE /admin/breakers?host=H   手动关闭熔断
+// GET    /admin/subscriptions      查看订阅, 可按 ?event=E&tenant=T 过滤
+// POST   /admin/subscriptions      新增或修改订阅, 请求体为 Subscription 的 json, 没有 id 时新增
+// DELETE /admin/subscriptions?id=I 删除订阅
+//
+// 配置 api key (SetAdminKeys) 后所有接口都需要 api key; 订阅接口返回的 secret 替换为 REDACTED_SECRET
+func NewAdminHandler(store RetryStore, topic string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/delayed", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			entries, err := ListDelayed(store, topic)
+			writeAdminResult(w, entries, err)
+		case "DELETE":
+			cancelled, err := CancelDelayed(store, topic, r.URL.Query().Get("member"))
+			writeCancelResult(w, cancelled, err)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/admin/retries", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			entries, err := ListRetries(store, topic)
+			writeAdminResult(w, entries, err)
+		case "DELETE":
+			cancelled, err := CancelRetry(store, topic, r.URL.Query().Get("member"))
+			writeCancelResult(w, cancelled, err)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/admin/dead", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		entries, err := ListDead(store, topic)
+		writeAdminResult(w, entries, err)
+	})
+	mux.HandleFunc("/admin/breakers", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			writeAdminResult(w, BreakerStates(), nil)
+		case "DELETE":
+			writeCancelResult(w, ResetBreaker(r.URL.Query().Get("host")), nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/admin/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			items, err := ListSubscriptions(store)
+			items = filterSubscriptions(items, r.URL.Query().Get("event"), r.URL.Query().Get("tenant"))
+			for i := range items {
+				items[i] = redactSubscription(items[i])
+			}
+			writeAdminResult(w, items, err)
+		case "POST":
+			var sub Subscription
+			if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := checkSubscription(sub); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if sub.Secret == REDACTED_SECRET {
+				existing, found, err := GetSubscription(store, sub.Id)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if sub.Id == "" || !found {
+					http.Error(w, "secret is required", http.StatusBadRequest)
+					return
+				}
+				sub.Secret = existing.Secret
+			}
+			saved, err := SaveSubscription(store, sub)
+			writeAdminResult(w, redactSubscription(saved), err)
+		case "DELETE":
+			deleted, err := DeleteSubscription(store, r.URL.Query().Get("id"))
+			writeCancelResult(w, deleted, err)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return &adminHandler{mux: mux}
+}
+
+type adminHandler struct {
+	mux *http.ServeMux
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn := "adminHandler.ServeHTTP"
+
+	if len(adminKeys) == 0 {
+		// 订阅包含通知地址和签名的 secret, 没有配置 api key 时不开放
+		if r.URL.Path == "/admin/subscriptions" {
+			http.Error(w, "admin keys are not configured", http.StatusForbidden)
+			return
+		}
+	} else if !MatchApiKey(RequestApiKey(r), adminKeys) {
+		glog.Warningf("@%s, invalid api key, method=%s, path=%s, remote=%s", fn, r.Method, r.URL.Path, r.RemoteAddr)
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+// 请求中的 api key: 请求头 X-Api-Key 或 Authorization: Bearer
+func RequestApiKey(r *http.Request) string {
+	key := r.Header.Get(HEADER_API_KEY)
+	if key == "" {
+		key = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	return key
+}
+
+// key 是否与 keys 之一相同, 按固定时间比较, 空 key 不匹配
+func MatchApiKey(key string, keys []string) bool {
+	if key == "" {
+		return false
+	}
+	for _, k := range keys {
+		if k != "" && subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func redactSubscription(sub Subscription) Subscription {
+	if sub.Secret != "" {
+		sub.Secret = REDACTED_SECRET
+	}
+	return sub
+}
+
+func filterSubscriptions(items []Subscription, event string, tenant string) []Subscription {
+	filtered := make([]Subscription, 0, len(items))
+	for _, sub := range items {
+		if (event == "" || sub.Event == event) && (tenant == "" || sub.Tenant == tenant) {
+			filtered = append(filtered, sub)
+		}
+	}
+	return filtered
+}
+
+// 延迟队列中的消息, 按发送时间排序
+func ListDelayed(store RetryStore, topic string) (entries []PendingEntry, err error) {
+	fn := "ListDelayed"
+
+	zsetKey := fmt.Sprintf(FORMAT_DELAY, topic)
+	var members []ScheduledMember
+	if members, err = store.Scheduled(zsetKey); err != nil {
+		glog.Errorf("@%s, store.Scheduled failed, err=%s, key=%s", fn, err, zsetKey)
+		return
+	}
+	entries = make([]PendingEntry, 0, len(members))
+	for _, member := range members {
+		var entry PendingEntry
+		if entry, err = getPendingEntry(store, topic, zsetKey, member.Member); err != nil {
+			return
+		}
+		entry.NextTime = member.At
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// 取消延迟发送, cancelled 表示消息是否在延迟队列中
+func CancelDelayed(store RetryStore, topic string, member string) (cancelled bool, err error) {
+	fn := "CancelDelayed"
+
+	zsetKey := fmt.Sprintf(FORMAT_DELAY, topic)
+	if cancelled, err = store.Unschedule(zsetKey, member); err != nil {
+		glog.Errorf("@%s, store.Unschedule failed, err=%s, key=%s, member=%s", fn, err, zsetKey, member)
+		return
+	}
+	if cancelled {
+		err = deletePending(store, topic, member)
+		glog.Infof("@%s, delayed message cancelled, topic=%s, member=%s", fn, topic, member)
+	}
+	return
+}
+
+// 各级重试列表中的消息
+func ListRetries(store RetryStore, topic string) (entries []PendingEntry, err error) {
+	entries = []PendingEntry{}
+	for _, listKey := range RetryLists(topic) {
+		var listEntries []PendingEntry
+		if listEntries, err = listPending(store, topic, listKey); err != nil {
+			return
+		}
+		entries = append(entries, listEntries...)
+	}
+	return
+}
+
+// 取消重试, cancelled 表示消息是否在重试列表中
+func CancelRetry(store RetryStore, topic string, member string) (cancelled bool, err error) {
+	fn := "CancelRetry"
+
+	for _, listKey := range RetryLists(topic) {
+		var n int64
+		if n, err = store.Remove(listKey, member); err != nil {
+			glog.Errorf("@%s, store.Remove failed, err=%s, key=%s, member=%s", fn, err, listKey, member)
+			return
+		}
+		cancelled = cancelled || n > 0
+	}
+	if cancelled {
+		err = deletePending(store, topic, member)
+		glog.Infof("@%s, retry cancelled, topic=%s, member=%s", fn, topic, member)
+	}
+	return
+}
+
+// 死信列表中的消息
+func ListDead(store RetryStore, topic string) (entries []PendingEntry, err error) {
+	return listPending(store, topic, fmt.Sprintf(FORMAT_DEAD, topic))
+}
+
+func listPending(store RetryStore, topic string, listKey string) (entries []PendingEntry, err error) {
+	fn := "listPending"
+
+	var members []string
+	if members, err = store.Range(listKey); err != nil {
+		glog.Errorf("@%s, store.Range failed, err=%s, key=%s", fn, err, listKey)
+		return
+	}
+	entries = make([]PendingEntry, 0, len(members))
+	for _, member := range members {
+		var entry PendingEntry
+		if entry, err = getPendingEntry(store, topic, listKey, member); err != nil {
+			return
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func getPendingEntry(store RetryStore, topic string, list string, member string) (entry PendingEntry, err error) {
+	fn := "getPendingEntry"
+
+	entry.List = list
+	entry.Member = member
+	retryData, hashKey, err := ParseMember(topic, member)
+	if err != nil {
+		glog.Errorf("@%s, ParseMember failed, err=%s, member=%s", fn, err, member)
+		return
+	}
+	entry.Offset = retryData.Offset
+	entry.Partition = retryData.Partition
+	entry.Destination = retryData.Destination
+
+	var fields map[string]string
+	if fields, err = store.GetFields(hashKey); err != nil {
+		glog.Errorf("@%s, store.GetFields failed, err=%s, key=%s", fn, err, hashKey)
+		return
+	}
+	// 记录已过期时只返回成员中的数据
+	if v, e := strconv.ParseInt(fields["partition"], 10, 32); e == nil {
+		entry.Partition = int32(v)
+	}
+	if v, e := strconv.ParseInt(fields["attempts"], 10, 32); e == nil {
+		entry.Attempts = int32(v)
+	}
+	if v, e := strconv.ParseInt(fields["next_time"], 10, 64); e == nil {
+		entry.NextTime = v
+	}
+	entry.Subscription = fields["subscription"]
+	entry.Reason = fields["reason"]
+	return
+}
+
+func deletePending(store RetryStore, topic string, member string) (err error) {
+	fn := "deletePending"
+
+	_, hashKey, err := ParseMember(topic, member)
+	if err != nil {
+		glog.Errorf("@%s, ParseMember failed, err=%s, member=%s", fn, err, member)
+		return
+	}
+	if err = store.Delete(hashKey); err != nil {
+		glog.Errorf("@%s, store.Delete failed, err=%s, key=%s", fn, err, hashKey)
+	}
+	return
+}
+
+func writeAdminResult(w http.ResponseWriter, data interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeCancelResult(w http.ResponseWriter, cancelled bool, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !cancelled {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	writeAdminResult(w, map[string]bool{"cancelled": true}, nil)
+}
@@ -0,0 +1,229 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用 api key, 测试结束时恢复
+func useAdminKeys(t *testing.T, keys ...string) {
+	SetAdminKeys(keys)
+	t.Cleanup(func() {
+		SetAdminKeys(nil)
+	})
+}
+
+// 请求管理接口, key 为空时不带 api key
+func adminRequest(handler http.Handler, method string, target string, body string, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HEADER_API_KEY, key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	handler := NewAdminHandler(NewMemoryStore(), "test")
+
+	// 没有配置 api key 时只有订阅接口不可用
+	assert.Equal(http.StatusOK, adminRequest(handler, "GET", "/admin/retries", "", "").Code)
+	assert.Equal(http.StatusForbidden, adminRequest(handler, "GET", "/admin/subscriptions", "", "").Code)
+
+	useAdminKeys(t, "k1", "k2")
+	assert.Equal(http.StatusUnauthorized, adminRequest(handler, "GET", "/admin/retries", "", "").Code)
+	assert.Equal(http.StatusUnauthorized, adminRequest(handler, "GET", "/admin/subscriptions", "", "wrong").Code)
+	assert.Equal(http.StatusOK, adminRequest(handler, "GET", "/admin/subscriptions", "", "k2").Code)
+
+	req := httptest.NewRequest("GET", "/admin/subscriptions", nil)
+	req.Header.Set("Authorization", "Bearer k1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+func TestAdminSubscriptionSecret(t *testing.T) {
+	assert := assert.New(t)
+	useAdminKeys(t, "k")
+
+	store := NewMemoryStore()
+	handler := NewAdminHandler(store, "test")
+
+	w := adminRequest(handler, "POST", "/admin/subscriptions", `{"event":"order.paid","url":"http://a.com","secret":"abc"}`, "k")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotContains(w.Body.String(), "abc")
+	var saved Subscription
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &saved))
+	assert.Equal(REDACTED_SECRET, saved.Secret)
+
+	w = adminRequest(handler, "GET", "/admin/subscriptions", "", "k")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotContains(w.Body.String(), "abc")
+	var items []Subscription
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &items))
+	assert.Len(items, 1)
+	assert.Equal(REDACTED_SECRET, items[0].Secret)
+
+	// 原样提交 REDACTED_SECRET 时不修改 secret
+	items[0].Url = "http://b.com"
+	body, _ := json.Marshal(items[0])
+	assert.Equal(http.StatusOK, adminRequest(handler, "POST", "/admin/subscriptions", string(body), "k").Code)
+	sub, found, err := GetSubscription(store, saved.Id)
+	assert.Nil(err)
+	assert.True(found)
+	assert.Equal("http://b.com", sub.Url)
+	assert.Equal("abc", sub.Secret)
+
+	// 新增订阅不能使用 REDACTED_SECRET
+	w = adminRequest(handler, "POST", "/admin/subscriptions", `{"event":"order.paid","url":"http://a.com","secret":"******"}`, "k")
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestAdminDelayed(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	source := NewMemorySource()
+	retrier := NewRetrier("mytopic", store, source)
+	handler := NewAdminHandler(store, "mytopic")
+	server, requests := testReceiver(http.StatusOK)
+	defer server.Close()
+
+	// 未到发送时间的消息放入延迟队列
+	for i, delay := range []int64{120, 60} {
+		msg := testMessage(int64(10+i), `{"id":1}`, MessageMeta{Url: server.URL, Delay: delay})
+		source.Add(msg)
+		assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	}
+	w := adminRequest(handler, "GET", "/admin/delayed", "", "")
+	assert.Equal(http.StatusOK, w.Code)
+	var entries []PendingEntry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Equal([]PendingEntry{
+		{List: "mytopic-zset-delayed", Member: "1:11:0", Offset: 11, Partition: 1, NextTime: 1500000060},
+		{List: "mytopic-zset-delayed", Member: "1:10:0", Offset: 10, Partition: 1, NextTime: 1500000120},
+	}, entries)
+
+	// 取消后不再发送, 重复取消返回 404
+	assert.Equal(http.StatusOK, adminRequest(handler, "DELETE", "/admin/delayed?member=1:11:0", "", "").Code)
+	assert.Equal(http.StatusNotFound, adminRequest(handler, "DELETE", "/admin/delayed?member=1:11:0", "", "").Code)
+	fields, _ := store.GetFields("mytopic-hash-1-11-0")
+	assert.Empty(fields)
+
+	clock.Advance(2 * time.Minute)
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(requests))
+	assert.Equal("[]\n", adminRequest(handler, "GET", "/admin/delayed", "", "").Body.String())
+}
+
+func TestAdminRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	handler := NewAdminHandler(store, "mytopic")
+	lists := RetryLists("mytopic")
+
+	assert.Nil(gotoRetry(store, "mytopic", MessageRetry{Offset: 10, Partition: 1}, lists[0]))
+	assert.Nil(gotoRetry(store, "mytopic", MessageRetry{Offset: 11, Partition: 1, Destination: 1, Attempts: 1, NextTime: 1}, lists[1]))
+	w := adminRequest(handler, "GET", "/admin/retries", "", "")
+	assert.Equal(http.StatusOK, w.Code)
+	var entries []PendingEntry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Equal([]PendingEntry{
+		{List: lists[0], Member: "1:10:0", Offset: 10, Partition: 1, Attempts: 1, NextTime: clock.Now().Add(4 * time.Minute).Unix()},
+		{List: lists[1], Member: "1:11:1", Offset: 11, Partition: 1, Destination: 1, Attempts: 2, NextTime: clock.Now().Add(10 * time.Minute).Unix()},
+	}, entries)
+
+	assert.Equal(http.StatusOK, adminRequest(handler, "DELETE", "/admin/retries?member=1:11:1", "", "").Code)
+	assert.Equal(http.StatusNotFound, adminRequest(handler, "DELETE", "/admin/retries?member=1:11:1", "", "").Code)
+	assert.Equal(http.StatusNotFound, adminRequest(handler, "DELETE", "/admin/retries?member=1:99:0", "", "").Code)
+	members, _ := store.Range(lists[1])
+	assert.Empty(members)
+	fields, _ := store.GetFields("mytopic-hash-1-11-1")
+	assert.Empty(fields)
+	assert.Equal(http.StatusMethodNotAllowed, adminRequest(handler, "PUT", "/admin/retries", "", "").Code)
+}
+
+func TestAdminDead(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+	handler := NewAdminHandler(store, "mytopic")
+
+	assert.Nil(gotoDead(store, "mytopic", MessageRetry{Offset: 10, Partition: 1, Attempts: 8, Subscription: "s1"}, "capped"))
+	w := adminRequest(handler, "GET", "/admin/dead", "", "")
+	assert.Equal(http.StatusOK, w.Code)
+	var entries []PendingEntry
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &entries))
+	assert.Equal([]PendingEntry{
+		{List: "mytopic-list-dead", Member: "1:10:0", Offset: 10, Partition: 1, Subscription: "s1", Attempts: 8, Reason: "capped"},
+	}, entries)
+	assert.Equal(http.StatusMethodNotAllowed, adminRequest(handler, "DELETE", "/admin/dead", "", "").Code)
+}
+
+func TestAdminSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+	useAdminKeys(t, "k")
+
+	store := NewMemoryStore()
+	handler := NewAdminHandler(store, "mytopic")
+
+	var saved []Subscription
+	for _, body := range []string{
+		`{"event":"order.paid","tenant":"t1","url":"http://a.com"}`,
+		`{"event":"order.paid","url":"http://b.com"}`,
+		`{"event":"order.refunded","tenant":"t1","url":"http://c.com"}`,
+	} {
+		w := adminRequest(handler, "POST", "/admin/subscriptions", body, "k")
+		assert.Equal(http.StatusOK, w.Code)
+		var sub Subscription
+		assert.Nil(json.Unmarshal(w.Body.Bytes(), &sub))
+		assert.NotEmpty(sub.Id)
+		saved = append(saved, sub)
+	}
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/admin/subscriptions", `{"event":"order.paid","url":"not a url"}`, "k").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/admin/subscriptions", `{"url":"http://a.com"}`, "k").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/admin/subscriptions", `{"event":"order.paid","channel":"pigeon","url":"http://a.com"}`, "k").Code)
+	assert.Equal(http.StatusOK, adminRequest(handler, "POST", "/admin/subscriptions", `{"event":"order.paid","channel":"email","url":"mailto:ops@example.com"}`, "k").Code)
+	assert.Equal(http.StatusBadRequest, adminRequest(handler, "POST", "/admin/subscriptions", `oops`, "k").Code)
+
+	list := func(query string) (items []Subscription) {
+		w := adminRequest(handler, "GET", "/admin/subscriptions"+query, "", "k")
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Nil(json.Unmarshal(w.Body.Bytes(), &items))
+		return
+	}
+	assert.Len(list(""), 4)
+	assert.Len(list("?event=order.paid"), 3)
+	assert.Len(list("?tenant=t1"), 2)
+	assert.Len(list("?event=order.paid&tenant=t1"), 1)
+
+	// 指定 id 时修改
+	w := adminRequest(handler, "POST", "/admin/subscriptions", `{"id":"`+saved[1].Id+`","event":"order.paid","url":"http://d.com"}`, "k")
+	assert.Equal(http.StatusOK, w.Code)
+	sub, found, _ := GetSubscription(store, saved[1].Id)
+	assert.True(found)
+	assert.Equal("http://d.com", sub.Url)
+	assert.Len(list(""), 4)
+
+	assert.Equal(http.StatusOK, adminRequest(handler, "DELETE", "/admin/subscriptions?id="+saved[0].Id, "", "k").Code)
+	assert.Equal(http.StatusNotFound, adminRequest(handler, "DELETE", "/admin/subscriptions?id="+saved[0].Id, "", "k").Code)
+	assert.Len(list(""), 3)
+	assert.Equal(http.StatusMethodNotAllowed, adminRequest(handler, "PUT", "/admin/subscriptions", "", "k").Code)
+}
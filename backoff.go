@@ -0,0 +1,131 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 同一次处理中的立即重试 (发送失败后不放入重试列表, 等待后再次发送)
+// 只重试可能很快恢复的失败: 网络错误, 超时, 以及 Statuses 中的 http 状态码
+// 超过 MaxTries 或 Budget 后放入重试列表, 按正常的重试间隔重试
+type RetryPolicy struct {
+	MaxTries   int           // 最多发送次数, 包括第一次, 1 表示不立即重试
+	Initial    time.Duration // 第一次重试前等待
+	Max        time.Duration // 最长等待
+	Multiplier float64       // 每次等待是上一次的倍数
+	Jitter     float64       // 0 到 1, 等待时间随机增减的比例, 避免同时重试
+	Budget     time.Duration // 发送和等待的总时间, 下一次等待会超过时不再立即重试
+	Statuses   []int         // 立即重试的 http 状态码
+}
+
+var DEFAULT_RETRY_POLICY = RetryPolicy{
+	MaxTries:   3,
+	Initial:    time.Second,
+	Max:        10 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+	Budget:     60 * time.Second,
+	Statuses:   []int{408, 429, 502, 503, 504},
+}
+
+var retryPolicy = struct {
+	sync.RWMutex
+	policy RetryPolicy
+	rand   *rand.Rand
+}{policy: DEFAULT_RETRY_POLICY, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// 设置立即重试的策略, 没有指定的使用 DEFAULT_RETRY_POLICY, 由启动程序根据配置设置
+func SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxTries <= 0 {
+		policy.MaxTries = DEFAULT_RETRY_POLICY.MaxTries
+	}
+	if policy.Initial <= 0 {
+		policy.Initial = DEFAULT_RETRY_POLICY.Initial
+	}
+	if policy.Max < policy.Initial {
+		policy.Max = policy.Initial
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = DEFAULT_RETRY_POLICY.Multiplier
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		policy.Jitter = DEFAULT_RETRY_POLICY.Jitter
+	}
+	if policy.Budget <= 0 {
+		policy.Budget = DEFAULT_RETRY_POLICY.Budget
+	}
+	if policy.Statuses == nil {
+		policy.Statuses = DEFAULT_RETRY_POLICY.Statuses
+	}
+
+	retryPolicy.Lock()
+	defer retryPolicy.Unlock()
+	retryPolicy.policy = policy
+}
+
+func getRetryPolicy() RetryPolicy {
+	retryPolicy.RLock()
+	defer retryPolicy.RUnlock()
+	return retryPolicy.policy
+}
+
+// 第 try 次发送失败后的等待时间: Initial * Multiplier^(try-1), 不超过 Max, 再随机增减 Jitter
+func (p RetryPolicy) backoff(try int) time.Duration {
+	d := float64(p.Initial) * math.Pow(p.Multiplier, float64(try-1))
+	if d > float64(p.Max) {
+		d = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		retryPolicy.Lock()
+		r := retryPolicy.rand.Float64()
+		retryPolicy.Unlock()
+		d += d * p.Jitter * (2*r - 1)
+	}
+	return time.Duration(d)
+}
+
+// 发送结果是否可以立即重试
+func (p RetryPolicy) retryable(outcome Outcome) bool {
+	if outcome.Status != SEND_RETRY {
+		return false
+	}
+	if outcome.Err != nil {
+		// 程序退出等取消不重试, 网络错误和超时重试
+		return !errors.Is(outcome.Err, context.Canceled)
+	}
+	for _, status := range p.Statuses {
+		if outcome.StatusCode == status {
+			return true
+		}
+	}
+	return false
+}
+
+// 不会因重试而成功的错误, 如 headers 不是 json 对象, 请求体无法按 encoding 编码
+type permanentError struct {
+	error
+}
+
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var e permanentError
+	return errors.As(err, &e)
+}
+
+// 发送出错时的状态
+func errorStatus(err error) string {
+	if isPermanent(err) {
+		return SEND_PERMANENT
+	}
+	return SEND_RETRY
+}
@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := RetryPolicy{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2}
+	assert.Equal(time.Second, policy.backoff(1))
+	assert.Equal(2*time.Second, policy.backoff(2))
+	assert.Equal(4*time.Second, policy.backoff(3))
+	assert.Equal(5*time.Second, policy.backoff(4))
+
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		d := policy.backoff(2)
+		assert.True(d >= 1600*time.Millisecond && d <= 2400*time.Millisecond, d)
+	}
+
+	SetRetryPolicy(RetryPolicy{MaxTries: 5, Jitter: 2})
+	defer SetRetryPolicy(DEFAULT_RETRY_POLICY)
+	policy = getRetryPolicy()
+	assert.Equal(5, policy.MaxTries)
+	assert.Equal(DEFAULT_RETRY_POLICY.Initial, policy.Initial)
+	assert.Equal(DEFAULT_RETRY_POLICY.Jitter, policy.Jitter)
+	assert.Equal(DEFAULT_RETRY_POLICY.Statuses, policy.Statuses)
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := DEFAULT_RETRY_POLICY
+	assert.True(policy.retryable(Outcome{Status: SEND_RETRY, Err: errors.New("connection refused")}))
+	assert.True(policy.retryable(Outcome{Status: SEND_RETRY, Err: context.DeadlineExceeded}))
+	assert.True(policy.retryable(Outcome{Status: SEND_RETRY, StatusCode: 503}))
+	assert.True(policy.retryable(Outcome{Status: SEND_RETRY, StatusCode: 429}))
+	// 返回内容不符合期望, 等待后重试
+	assert.False(policy.retryable(Outcome{Status: SEND_RETRY, StatusCode: 200}))
+	assert.False(policy.retryable(Outcome{Status: SEND_RETRY, StatusCode: 500}))
+	assert.False(policy.retryable(Outcome{Status: SEND_RETRY, Err: context.Canceled}))
+	assert.False(policy.retryable(Outcome{Status: SEND_PERMANENT, StatusCode: 503}))
+	assert.False(policy.retryable(Outcome{Status: SEND_DELIVERED, StatusCode: 200}))
+}
+
+func TestPermanentErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// headers 不是 json 对象, 请求体无法编码: 不再重试
+	outcome := httpChannel{}.Send(context.Background(), Delivery{Content: `{}`, Destination: Destination{Url: server.URL, Headers: `[1]`}})
+	assert.Equal(SEND_PERMANENT, outcome.Status)
+	assert.True(isPermanent(outcome.Err))
+	outcome = httpChannel{}.Send(context.Background(), Delivery{Content: `not json`, Destination: Destination{Url: server.URL, Encoding: ENCODING_FORM}})
+	assert.Equal(SEND_PERMANENT, outcome.Status)
+
+	outcome = httpChannel{}.Send(context.Background(), Delivery{Content: `{}`, Destination: Destination{Url: server.URL}})
+	assert.Equal(SEND_RETRY, outcome.Status)
+	assert.Equal(503, outcome.StatusCode)
+	assert.True(DEFAULT_RETRY_POLICY.retryable(outcome))
+
+	assert.Nil(permanent(nil))
+	assert.Equal(SEND_RETRY, errorStatus(errors.New("timeout")))
+}
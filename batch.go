@@ -0,0 +1,276 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+const (
+	BATCH_FORMAT_JSON   = "json"   // json 数组
+	BATCH_FORMAT_NDJSON = "ndjson" // 每行一个 json
+)
+
+// 合并发送规则, Prefix 的匹配方式与限流相同
+type BatchRule struct {
+	Prefix  string
+	MaxSize int           // 达到该数量时立即发送
+	MaxWait time.Duration // 第一条通知最多等待多久后发送, 必须大于 0
+	Format  string        // json (默认) 或 ndjson
+}
+
+type batchItem struct {
+	msg       *sarama.ConsumerMessage
+	id        string
+	content   string
+	retryData MessageRetry
+	expiresAt int64 // 过期时间 (Unix 时间戳), 0 表示不过期, 发送前再次检查
+}
+
+// 同一通知地址 (和 headers, checker, secret) 的一批通知
+type batch struct {
+	key         string
+	destination Destination
+	rule        BatchRule
+	store       RetryStore
+	items       []batchItem
+	timer       *time.Timer
+}
+
+type batcher struct {
+	mu      sync.Mutex
+	rules   []BatchRule
+	batches map[string]*batch
+	flushes sync.WaitGroup
+}
+
+var batches = &batcher{batches: make(map[string]*batch)}
+
+// 设置合并发送规则, 由启动程序根据配置设置, MaxWait 不大于 0 时返回错误
+func SetBatchRules(rules []BatchRule) (err error) {
+	sorted := make([]BatchRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Prefix == "" || rule.MaxSize <= 1 {
+			continue
+		}
+		// MaxWait 为 0 时定时器立即发送, 合并不起作用
+		if rule.MaxWait <= 0 {
+			return fmt.Errorf("maxwait of batch rule %s must be positive", rule.Prefix)
+		}
+		sorted = append(sorted, rule)
+	}
+	// 最长的前缀优先匹配
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	batches.mu.Lock()
+	defer batches.mu.Unlock()
+	batches.rules = sorted
+	return
+}
+
+// 立即发送所有未发送的通知并等待完成, 程序退出前调用
+func FlushBatches() {
+	batches.mu.Lock()
+	pending := make([]*batch, 0, len(batches.batches))
+	for _, bt := range batches.batches {
+		pending = append(pending, bt)
+	}
+	batches.mu.Unlock()
+
+	for _, bt := range pending {
+		batches.flush(bt)
+	}
+	batches.flushes.Wait()
+}
+
+func (b *batcher) match(url string) (rule BatchRule, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, rule = range b.rules {
+		if matchPrefix(rule.Prefix, url) {
+			return rule, true
+		}
+	}
+	return BatchRule{}, false
+}
+
+// 加入一批通知, 达到 MaxSize 时在当前协程发送, 否则等待 MaxWait 后发送
+func (b *batcher) add(store RetryStore, msg *sarama.ConsumerMessage, id string, content string, destination Destination, retryData MessageRetry, expiresAt int64, rule BatchRule) {
+	key := destination.Url + "\n" + destination.Headers + "\n" + destination.Checker + "\n" + destination.Secret
+
+	b.mu.Lock()
+	bt, ok := b.batches[key]
+	if !ok {
+		bt = &batch{key: key, destination: destination, rule: rule, store: store}
+		b.batches[key] = bt
+		bt.timer = time.AfterFunc(rule.MaxWait, func() {
+			b.flush(bt)
+		})
+	}
+	bt.items = append(bt.items, batchItem{msg: msg, id: id, content: content, retryData: retryData, expiresAt: expiresAt})
+	full := len(bt.items) >= rule.MaxSize
+	b.mu.Unlock()
+
+	if full {
+		b.flush(bt)
+	}
+}
+
+// 发送一批通知, 只有失败的通知放入重试列表
+// 由定时器或程序退出时的 FlushBatches 调用, 不随处理消息的 ctx 取消
+func (b *batcher) flush(bt *batch) {
+	fn := "batcher.flush"
+	ctx := context.Background()
+
+	b.mu.Lock()
+	if b.batches[bt.key] != bt {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.batches, bt.key)
+	bt.timer.Stop()
+	b.flushes.Add(1)
+	b.mu.Unlock()
+	defer b.flushes.Done()
+
+	// 等待期间过期的通知不再发送
+	items := bt.items[:0]
+	for _, item := range bt.items {
+		if isExpired(item.expiresAt) {
+			expire(bt.store, item.msg, item.id, bt.destination, item.retryData, item.expiresAt)
+			continue
+		}
+		items = append(items, item)
+	}
+	bt.items = items
+	if len(bt.items) == 0 {
+		return
+	}
+
+	// 超过限流时整批放入延迟队列
+	url := bt.destination.Url
+	wait, err := limiter.take(ctx, bt.store, url)
+	if err == nil && wait > 0 {
+		for _, item := range bt.items {
+			throttle(ctx, bt.store, item.msg, item.retryData, wait)
+		}
+		return
+	}
+
+	failed := make([]bool, len(bt.items))
+	for i := range failed {
+		failed[i] = true
+	}
+	var (
+		statusCode int
+		result     string
+	)
+	host := urlHost(url)
+	if err != nil {
+		glog.Errorf("@%s, limiter.take failed, skip post, err=%s, host=%s", fn, err, host)
+	} else if breakers.allow(host) {
+		body, encoding := encodeBatch(bt.items, bt.rule.Format)
+		result, statusCode, err = post(ctx, body, url, bt.destination.Headers, encoding, bt.destination.Secret, Timeouts{})
+		if err == nil {
+			checker := getDeliveryChecker(bt.destination.Checker)
+			failed = checkBatchResult(func(statusCode int, result string) bool {
+				return checker(Delivery{Destination: bt.destination}, statusCode, result)
+			}, statusCode, result, len(bt.items))
+		}
+		breakers.record(host, err == nil && !all(failed))
+		result = auditCopy(result)
+		logf(LOG_INFO, "batch sent", Fields{"fn": fn, "url_host": host, "size": len(bt.items), "status_code": statusCode, "err": err, FIELD_RESPONSE: result})
+	} else {
+		glog.Warningf("@%s, circuit breaker is open, skip post, host=%s", fn, host)
+	}
+
+	for i, item := range bt.items {
+		if !failed[i] {
+			countOutcome(OUTCOME_DELIVERED)
+			reportStatus(item.msg, item.id, bt.destination, item.retryData, OUTCOME_DELIVERED, Outcome{StatusCode: statusCode, Result: result})
+			continue
+		}
+		if err := gotoRetry(bt.store, item.msg.Topic, item.retryData, NextRetryList(item.msg.Topic, 0)); err != nil {
+			glog.Errorf("@%s, gotoRetry failed, err=%s, topic=%s, retryData=%+v", fn, err, item.msg.Topic, item.retryData)
+			continue
+		}
+		countOutcome(OUTCOME_RETRY_SCHEDULED)
+		reportStatus(item.msg, item.id, bt.destination, item.retryData, OUTCOME_RETRY_SCHEDULED, Outcome{StatusCode: statusCode, Result: result})
+	}
+}
+
+// 合并为 json 数组或 ndjson, 不是 json 的内容按字符串处理
+func encodeBatch(items []batchItem, format string) (body string, encoding string) {
+	var buf bytes.Buffer
+	if format == BATCH_FORMAT_NDJSON {
+		for _, item := range items {
+			buf.Write(compactJson(item.content))
+			buf.WriteByte('\n')
+		}
+		return buf.String(), ENCODING_NDJSON
+	}
+
+	buf.WriteByte('[')
+	for i, item := range items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(compactJson(item.content))
+	}
+	buf.WriteByte(']')
+	return buf.String(), ENCODING_JSON
+}
+
+func compactJson(content string) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(content)); err == nil {
+		return buf.Bytes()
+	}
+	encoded, _ := json.Marshal(content)
+	return encoded
+}
+
+// 检查合并发送的返回, 返回每条通知是否失败
+// 整体返回为成功时全部成功, 返回与请求等长的 json 数组时逐条检查, 否则全部失败
+func checkBatchResult(checker Checker, statusCode int, ret string, size int) (failed []bool) {
+	failed = make([]bool, size)
+	if !checker(statusCode, ret) {
+		return
+	}
+
+	var results []json.RawMessage
+	if err := json.Unmarshal([]byte(ret), &results); err != nil || len(results) != size {
+		for i := range failed {
+			failed[i] = true
+		}
+		return
+	}
+	for i, result := range results {
+		var str string
+		if err := json.Unmarshal(result, &str); err == nil {
+			failed[i] = checker(statusCode, str)
+		} else {
+			failed[i] = checker(statusCode, string(result))
+		}
+	}
+	return
+}
+
+func all(values []bool) bool {
+	for _, v := range values {
+		if !v {
+			return false
+		}
+	}
+	return true
+}
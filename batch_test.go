@@ -0,0 +1,182 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用合并发送规则, 测试结束时发送剩余的通知并恢复
+func useBatchRules(t *testing.T, rules ...BatchRule) {
+	if err := SetBatchRules(rules); err != nil {
+		t.Fatalf("SetBatchRules failed, err=%s", err)
+	}
+	t.Cleanup(func() {
+		FlushBatches()
+		SetBatchRules(nil)
+	})
+}
+
+// 返回 response 的接收方, requests 返回收到的请求 (path 和请求体)
+func batchReceiver(response string) (server *httptest.Server, requests func() []string) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, r.URL.Path+" "+string(body))
+		mu.Unlock()
+		w.Write([]byte(response))
+	}))
+	requests = func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, received...)
+	}
+	return
+}
+
+func fireBatch(t *testing.T, store RetryStore, offset int64, url string, meta MessageMeta) {
+	meta.Url = url
+	if err := Fire(context.Background(), store, testMessage(offset, fmt.Sprintf(`{"id":%d}`, offset), meta), "", MessageRetry{}); err != nil {
+		t.Fatalf("Fire failed, err=%s", err)
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	items := []batchItem{{content: `{"id": 1}`}, {content: `{"id":2}`}, {content: `plain`}}
+
+	body, encoding := encodeBatch(items, BATCH_FORMAT_JSON)
+	assert.Equal(ENCODING_JSON, encoding)
+	assert.Equal(`[{"id":1},{"id":2},"plain"]`, body)
+
+	body, encoding = encodeBatch(items, BATCH_FORMAT_NDJSON)
+	assert.Equal(ENCODING_NDJSON, encoding)
+	assert.Equal("{\"id\":1}\n{\"id\":2}\n\"plain\"\n", body)
+}
+
+func TestCheckBatchResult(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]bool{false, false}, checkBatchResult(getChecker(""), 200, "success", 2))
+	assert.Equal([]bool{false, false}, checkBatchResult(getChecker(""), 200, `{"code":"0000"}`, 2))
+	assert.Equal([]bool{false, true, true}, checkBatchResult(getChecker(""), 200, `["success", "fail", {"code":"0001"}]`, 3))
+	assert.Equal([]bool{true, false}, checkBatchResult(getChecker(""), 200, `[{"code":"1000"}, {"code":"0000"}]`, 2))
+
+	// 长度不一致或无法解析时全部失败
+	assert.Equal([]bool{true, true}, checkBatchResult(getChecker(""), 200, `["success"]`, 2))
+	assert.Equal([]bool{true, true}, checkBatchResult(getChecker(""), 200, `error`, 2))
+}
+
+func TestSetBatchRules(t *testing.T) {
+	assert := assert.New(t)
+
+	defer SetBatchRules(nil)
+	assert.NotNil(SetBatchRules([]BatchRule{{Prefix: "a.com", MaxSize: 10}}))
+	assert.Nil(SetBatchRules([]BatchRule{{Prefix: "a.com", MaxSize: 10, MaxWait: time.Second}, {Prefix: "b.com", MaxSize: 1}}))
+	_, ok := batches.match("http://a.com/notify")
+	assert.True(ok)
+	_, ok = batches.match("http://b.com/notify")
+	assert.False(ok)
+}
+
+func TestBatchGrouping(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+	server, requests := batchReceiver("success")
+	defer server.Close()
+	useBatchRules(t, BatchRule{Prefix: urlHost(server.URL), MaxSize: 3, MaxWait: time.Hour})
+
+	// 按通知地址合并, 达到 MaxSize 时立即发送
+	fireBatch(t, store, 1, server.URL+"/a", MessageMeta{})
+	fireBatch(t, store, 2, server.URL+"/a", MessageMeta{})
+	fireBatch(t, store, 3, server.URL+"/b", MessageMeta{})
+	fireBatch(t, store, 4, server.URL+"/a", MessageMeta{Headers: `{"X-Tenant":"t1"}`})
+	assert.Empty(requests())
+	fireBatch(t, store, 5, server.URL+"/a", MessageMeta{})
+	assert.Equal([]string{`/a [{"id":1},{"id":2},{"id":5}]`}, requests())
+
+	FlushBatches()
+	assert.ElementsMatch([]string{`/a [{"id":1},{"id":2},{"id":5}]`, `/b [{"id":3}]`, `/a [{"id":4}]`}, requests())
+	members, _ := store.Range(RetryLists("mytopic")[0])
+	assert.Empty(members)
+}
+
+func TestBatchMaxWait(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+	server, requests := batchReceiver("success")
+	defer server.Close()
+	useBatchRules(t, BatchRule{Prefix: urlHost(server.URL), MaxSize: 10, MaxWait: 50 * time.Millisecond, Format: BATCH_FORMAT_NDJSON})
+
+	fireBatch(t, store, 1, server.URL, MessageMeta{})
+	fireBatch(t, store, 2, server.URL, MessageMeta{})
+	assert.Empty(requests())
+	assert.Eventually(func() bool {
+		return len(requests()) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal([]string{"/ {\"id\":1}\n{\"id\":2}\n"}, requests())
+}
+
+func TestBatchRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	server, requests := batchReceiver(`["success","fail","success"]`)
+	defer server.Close()
+	useBatchRules(t, BatchRule{Prefix: urlHost(server.URL), MaxSize: 10, MaxWait: time.Hour})
+
+	// 逐条检查返回, 只有失败的通知放入重试列表
+	for offset := int64(1); offset <= 3; offset++ {
+		fireBatch(t, store, offset, server.URL, MessageMeta{})
+	}
+	FlushBatches()
+	assert.Len(requests(), 1)
+	members, _ := store.Range(RetryLists("mytopic")[0])
+	assert.Equal([]string{"1:2:0"}, members)
+	attempts, nextTime, _, _, err := loadRetryData(store, "mytopic-hash-1-2-0")
+	assert.Nil(err)
+	assert.Equal(int32(1), attempts)
+	assert.Equal(clock.Now().Add(4*time.Minute).Unix(), nextTime)
+}
+
+func TestBatchExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	server, requests := batchReceiver("success")
+	defer server.Close()
+	useBatchRules(t, BatchRule{Prefix: urlHost(server.URL), MaxSize: 10, MaxWait: time.Hour})
+
+	// 等待期间过期的通知不再发送
+	fireBatch(t, store, 1, server.URL, MessageMeta{Ttl: 60})
+	fireBatch(t, store, 2, server.URL, MessageMeta{})
+	clock.Advance(2 * time.Minute)
+	FlushBatches()
+	assert.Equal([]string{`/ [{"id":2}]`}, requests())
+
+	// 全部过期时不发送
+	fireBatch(t, store, 3, server.URL, MessageMeta{Ttl: 60})
+	clock.Advance(2 * time.Minute)
+	FlushBatches()
+	assert.Len(requests(), 1)
+	members, _ := store.Range(RetryLists("mytopic")[0])
+	assert.Empty(members)
+}
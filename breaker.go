@@ -0,0 +1,191 @@
+package notification
+
+import (
+	"expvar"
+	neturl "net/url"
+	"sync"
+	"time"
+)
+
+// 熔断状态
+const (
+	BREAKER_CLOSED    = "closed"
+	BREAKER_OPEN      = "open"
+	BREAKER_HALF_OPEN = "half_open"
+)
+
+// 熔断配置, FailureThreshold <= 0 表示不熔断
+type BreakerConfig struct {
+	FailureThreshold int           // 连续失败多少次后打开
+	OpenTimeout      time.Duration // 打开多久后进入半开状态
+	HalfOpenRequests int           // 半开状态下同时允许的试探请求数
+}
+
+// 对外展示的熔断状态
+type BreakerState struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"`
+	OpenedAt int64  `json:"opened_at"` // 最近一次打开的时间(Unix 时间戳)
+}
+
+type breaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	probes   int // 半开状态下进行中的试探请求数
+}
+
+// 按通知地址的 host 熔断, 每个进程独立计数
+type breakerGroup struct {
+	mu       sync.Mutex
+	config   BreakerConfig
+	breakers map[string]*breaker
+	now      func() time.Time
+}
+
+var DEFAULT_BREAKER_CONFIG = BreakerConfig{
+	FailureThreshold: 5,
+	OpenTimeout:      time.Minute,
+	HalfOpenRequests: 1,
+}
+
+var breakers = newBreakerGroup(DEFAULT_BREAKER_CONFIG)
+
+func init() {
+	expvar.Publish("notification_breakers", expvar.Func(func() interface{} {
+		return breakers.states()
+	}))
+}
+
+// 设置熔断配置, OpenTimeout 和 HalfOpenRequests 没有指定时使用 DEFAULT_BREAKER_CONFIG, 由启动程序根据配置设置
+func SetBreakerConfig(config BreakerConfig) {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	breakers.config = withBreakerDefaults(config)
+}
+
+// 打开后必须能进入半开状态并允许试探请求, 否则熔断不会关闭
+func withBreakerDefaults(config BreakerConfig) BreakerConfig {
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DEFAULT_BREAKER_CONFIG.OpenTimeout
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = DEFAULT_BREAKER_CONFIG.HalfOpenRequests
+	}
+	return config
+}
+
+// 当前所有 host 的熔断状态
+func BreakerStates() map[string]BreakerState {
+	return breakers.states()
+}
+
+// 手动关闭熔断, found 表示该 host 是否有熔断记录
+func ResetBreaker(host string) (found bool) {
+	return breakers.reset(host)
+}
+
+func newBreakerGroup(config BreakerConfig) *breakerGroup {
+	return &breakerGroup{
+		config:   withBreakerDefaults(config),
+		breakers: make(map[string]*breaker),
+		now:      func() time.Time { return timeNow() },
+	}
+}
+
+// 是否允许请求, 允许时必须调用 record 记录结果
+func (g *breakerGroup) allow(host string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.FailureThreshold <= 0 {
+		return true
+	}
+	b := g.get(host)
+	switch b.state {
+	case BREAKER_OPEN:
+		if g.now().Sub(b.openedAt) < g.config.OpenTimeout {
+			return false
+		}
+		b.state = BREAKER_HALF_OPEN
+		b.probes = 0
+		fallthrough
+	case BREAKER_HALF_OPEN:
+		if b.probes >= g.config.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// 记录请求结果, 半开状态下成功则关闭, 失败则重新打开
+func (g *breakerGroup) record(host string, success bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.config.FailureThreshold <= 0 {
+		return
+	}
+	b := g.get(host)
+	if b.state == BREAKER_HALF_OPEN && b.probes > 0 {
+		b.probes--
+	}
+	if success {
+		b.state = BREAKER_CLOSED
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == BREAKER_HALF_OPEN || b.failures >= g.config.FailureThreshold {
+		b.state = BREAKER_OPEN
+		b.openedAt = g.now()
+	}
+}
+
+func (g *breakerGroup) states() map[string]BreakerState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	states := make(map[string]BreakerState, len(g.breakers))
+	for host, b := range g.breakers {
+		state := BreakerState{State: b.state, Failures: b.failures}
+		if !b.openedAt.IsZero() {
+			state.OpenedAt = b.openedAt.Unix()
+		}
+		states[host] = state
+	}
+	return states
+}
+
+func (g *breakerGroup) reset(host string) (found bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, found = g.breakers[host]; found {
+		delete(g.breakers, host)
+	}
+	return
+}
+
+func (g *breakerGroup) get(host string) *breaker {
+	b, ok := g.breakers[host]
+	if !ok {
+		b = &breaker{state: BREAKER_CLOSED}
+		g.breakers[host] = b
+	}
+	return b
+}
+
+// 通知地址的 host, 作为熔断的维度
+func urlHost(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return url
+	}
+	// mailto: 等没有 host 的地址按 scheme 区分
+	if u.Host == "" && u.Opaque != "" {
+		return u.Scheme
+	}
+	return u.Host
+}
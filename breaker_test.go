@@ -0,0 +1,108 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1500000000, 0)
+	g := newBreakerGroup(BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	g.now = func() time.Time { return now }
+
+	// 连续失败达到阈值后打开, 其他 host 不受影响
+	for i := 0; i < 3; i++ {
+		assert.True(g.allow("a.com"))
+		g.record("a.com", false)
+	}
+	assert.False(g.allow("a.com"))
+	assert.True(g.allow("b.com"))
+	g.record("b.com", true)
+	assert.Equal(BREAKER_OPEN, g.states()["a.com"].State)
+	assert.Equal(BREAKER_CLOSED, g.states()["b.com"].State)
+
+	// 超时后半开, 只允许一个试探请求, 失败则重新打开
+	now = now.Add(time.Minute)
+	assert.True(g.allow("a.com"))
+	assert.False(g.allow("a.com"))
+	assert.Equal(BREAKER_HALF_OPEN, g.states()["a.com"].State)
+	g.record("a.com", false)
+	assert.False(g.allow("a.com"))
+	assert.Equal(BREAKER_OPEN, g.states()["a.com"].State)
+
+	// 试探成功则关闭
+	now = now.Add(time.Minute)
+	assert.True(g.allow("a.com"))
+	g.record("a.com", true)
+	assert.Equal(BREAKER_CLOSED, g.states()["a.com"].State)
+	assert.True(g.allow("a.com"))
+	g.record("a.com", true)
+
+	assert.True(g.reset("a.com"))
+	assert.False(g.reset("a.com"))
+}
+
+func TestBreakerDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	// 只配置阈值时使用默认的打开时间和试探请求数, 熔断能够关闭
+	now := time.Unix(1500000000, 0)
+	g := newBreakerGroup(BreakerConfig{FailureThreshold: 1})
+	g.now = func() time.Time { return now }
+	assert.Equal(DEFAULT_BREAKER_CONFIG.OpenTimeout, g.config.OpenTimeout)
+	assert.Equal(DEFAULT_BREAKER_CONFIG.HalfOpenRequests, g.config.HalfOpenRequests)
+
+	assert.True(g.allow("a.com"))
+	g.record("a.com", false)
+	assert.False(g.allow("a.com"))
+	now = now.Add(DEFAULT_BREAKER_CONFIG.OpenTimeout)
+	assert.True(g.allow("a.com"))
+	g.record("a.com", true)
+	assert.Equal(BREAKER_CLOSED, g.states()["a.com"].State)
+
+	SetBreakerConfig(BreakerConfig{FailureThreshold: 3, HalfOpenRequests: -1})
+	defer SetBreakerConfig(DEFAULT_BREAKER_CONFIG)
+	assert.Equal(BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute, HalfOpenRequests: 1}, breakers.config)
+}
+
+func TestBreakerPermanentProbe(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	SetBreakerConfig(BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+	defer SetBreakerConfig(DEFAULT_BREAKER_CONFIG)
+	store := NewMemoryStore()
+	server, _ := testReceiver(http.StatusBadGateway)
+	defer server.Close()
+	host := urlHost(server.URL)
+	defer ResetBreaker(host)
+
+	// 一次失败后打开
+	assert.Nil(Fire(context.Background(), store, testMessage(10, `{"id":1}`, MessageMeta{Url: server.URL}), "", MessageRetry{}))
+	assert.Equal(BREAKER_OPEN, BreakerStates()[host].State)
+
+	// 半开状态的试探请求因 headers 不是 json 永久失败, 仍然释放试探名额
+	clock.Advance(time.Minute)
+	assert.Nil(Fire(context.Background(), store, testMessage(11, `{"id":2}`, MessageMeta{Url: server.URL, Headers: "oops"}), "", MessageRetry{}))
+	assert.True(breakers.allow(host))
+	breakers.record(host, true)
+	assert.Equal(BREAKER_CLOSED, BreakerStates()[host].State)
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	g := newBreakerGroup(BreakerConfig{})
+	for i := 0; i < 10; i++ {
+		assert.True(g.allow("a.com"))
+		g.record("a.com", false)
+	}
+	assert.Empty(g.states())
+}
@@ -0,0 +1,96 @@
+package notification
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	CHANNEL_HTTP = "http" // http webhook, 默认通道
+
+	E_UNKNOWN_CHANNEL = "Unknown channel"
+)
+
+// 发送结果
+const (
+	SEND_DELIVERED = "delivered" // 成功
+	SEND_RETRY     = "retry"     // 失败, 可以重试
+	SEND_PERMANENT = "permanent" // 失败, 重试也不会成功, 放入死信列表
+)
+
+// 一次发送, Topic 等为 kafka 消息的信息, 供需要的通道使用
+type Delivery struct {
+	Content     string
+	Destination Destination
+	Topic       string
+	Partition   int32
+	Offset      int64
+	Key         string
+	Event       string
+	Tenant      string
+	Attempts    int32
+	Headers     map[string]string // kafka 消息 headers, 需要 kafka 0.11 以上的版本, 见 ConsumerVersion
+	Timeouts    Timeouts          // 已按 meta 和 host 配置确定的超时时间, ctx 已包含 Total
+}
+
+// 发送结果, Err 为网络等错误, 在同一次处理中会再尝试几次 (Status 为 SEND_RETRY 时)
+type Outcome struct {
+	Status     string
+	StatusCode int    // 通道的状态码, 如 http 状态码, 没有时为 0
+	Result     string // 返回内容, 用于日志和状态事件, 超过 ResponseConfig.AuditBytes 时截断
+	Err        error
+}
+
+// 发送通道, 重试, 延迟, 过期, 限流, 熔断和计数由 deliver 统一处理, 通道只负责一次发送
+type Channel interface {
+	// 检查通知地址, 不正确时不发送
+	Check(destination Destination) error
+	// 发送一次
+	Send(ctx context.Context, delivery Delivery) Outcome
+}
+
+var (
+	channelsMu sync.RWMutex
+	channels   = map[string]Channel{
+		CHANNEL_HTTP: httpChannel{},
+	}
+)
+
+// 注册发送通道, 同名的会被覆盖
+func RegisterChannel(name string, channel Channel) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	channels[name] = channel
+}
+
+// 按名称取得发送通道, 空字符串为 CHANNEL_HTTP
+func getChannel(name string) (channel Channel, ok bool) {
+	if name == "" {
+		name = CHANNEL_HTTP
+	}
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+	channel, ok = channels[name]
+	return
+}
+
+// http webhook: POST 到 Url, 由 Checker 检查返回
+type httpChannel struct{}
+
+func (httpChannel) Check(destination Destination) error {
+	return checkUrl(destination.Url)
+}
+
+func (httpChannel) Send(ctx context.Context, delivery Delivery) (outcome Outcome) {
+	destination := delivery.Destination
+	outcome.Result, outcome.StatusCode, outcome.Err = post(ctx, delivery.Content, destination.Url, destination.Headers, destination.Encoding, destination.Secret, delivery.Timeouts)
+	if outcome.Err != nil {
+		outcome.Status = errorStatus(outcome.Err)
+	} else if !getDeliveryChecker(destination.Checker)(delivery, outcome.StatusCode, outcome.Result) {
+		outcome.Status = SEND_DELIVERED
+	} else {
+		outcome.Status = SEND_RETRY
+	}
+	outcome.Result = auditCopy(outcome.Result)
+	return
+}
@@ -0,0 +1,70 @@
+package notification
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type stubChannel struct{}
+
+func (stubChannel) Check(destination Destination) error {
+	return nil
+}
+
+func (stubChannel) Send(ctx context.Context, delivery Delivery) Outcome {
+	return Outcome{Status: SEND_PERMANENT}
+}
+
+func TestGetChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	channel, ok := getChannel("")
+	assert.True(ok)
+	assert.Equal(httpChannel{}, channel)
+
+	_, ok = getChannel("stub")
+	assert.False(ok)
+
+	RegisterChannel("stub", stubChannel{})
+	t.Cleanup(func() {
+		channelsMu.Lock()
+		defer channelsMu.Unlock()
+		delete(channels, "stub")
+	})
+	channel, ok = getChannel("stub")
+	assert.True(ok)
+	assert.Equal(SEND_PERMANENT, channel.Send(context.Background(), Delivery{}).Status)
+}
+
+func TestHttpChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("success"))
+	}))
+	defer server.Close()
+
+	channel := httpChannel{}
+	assert.NotNil(channel.Check(Destination{Url: "not a url"}))
+	assert.Nil(channel.Check(Destination{Url: server.URL}))
+
+	outcome := channel.Send(context.Background(), Delivery{Content: `{"a":1}`, Destination: Destination{Url: server.URL}})
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.Equal(http.StatusOK, outcome.StatusCode)
+	assert.Equal(`{"a":1}`, body)
+
+	outcome = channel.Send(context.Background(), Delivery{Content: `{"a":1}`, Destination: Destination{Url: server.URL + "/fail", Checker: CHECKER_STATUS}})
+	assert.Equal(SEND_RETRY, outcome.Status)
+	assert.Equal(http.StatusInternalServerError, outcome.StatusCode)
+}
@@ -0,0 +1,212 @@
+package notification
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"time"
+)
+
+// 群机器人通道, 通知地址为机器人的 webhook, Destination.Secret 为机器人的签名密钥 (可选)
+// Content 已是该平台的 json 消息时原样发送, 否则作为文本消息发送
+const (
+	CHANNEL_DINGTALK = "dingtalk" // 钉钉
+	CHANNEL_WECOM    = "wecom"    // 企业微信
+	CHANNEL_FEISHU   = "feishu"   // 飞书
+	CHANNEL_SLACK    = "slack"    // Slack incoming webhook
+)
+
+// 各平台表示发送过快的错误码, 需要重试, 其他错误码 (token, 签名, 关键词, 消息格式等) 重试也不会成功
+const (
+	DINGTALK_TOO_FAST = 130101
+	WECOM_TOO_FAST    = 45009
+	FEISHU_TOO_FAST   = 11232
+)
+
+type chatbotChannel struct {
+	// 生成请求地址和请求体
+	format func(content string, url string, secret string, now time.Time) (requestUrl string, body string, err error)
+	// 根据返回判断发送结果, 不再重试时 err 为平台返回的错误
+	check func(statusCode int, result string) (status string, err error)
+}
+
+func init() {
+	channels[CHANNEL_DINGTALK] = chatbotChannel{format: formatDingtalk, check: checkDingtalk}
+	channels[CHANNEL_WECOM] = chatbotChannel{format: formatWecom, check: checkWecom}
+	channels[CHANNEL_FEISHU] = chatbotChannel{format: formatFeishu, check: checkFeishu}
+	channels[CHANNEL_SLACK] = chatbotChannel{format: formatSlack, check: checkSlack}
+}
+
+func (c chatbotChannel) Check(destination Destination) error {
+	return checkUrl(destination.Url)
+}
+
+func (c chatbotChannel) Send(ctx context.Context, delivery Delivery) (outcome Outcome) {
+	destination := delivery.Destination
+	url, body, err := c.format(delivery.Content, destination.Url, destination.Secret, time.Now())
+	if err != nil {
+		outcome.Status = SEND_PERMANENT
+		outcome.Err = err
+		return
+	}
+	outcome.Result, outcome.StatusCode, outcome.Err = post(ctx, body, url, destination.Headers, ENCODING_JSON, "", delivery.Timeouts)
+	if outcome.Err != nil {
+		outcome.Status = errorStatus(outcome.Err)
+		return
+	}
+	outcome.Status, outcome.Err = c.check(outcome.StatusCode, outcome.Result)
+	outcome.Result = auditCopy(outcome.Result)
+	return
+}
+
+// 钉钉: 签名为 base64(hmac_sha256(secret, timestamp + "\n" + secret)), 与毫秒时间戳一起加在地址上
+func formatDingtalk(content string, url string, secret string, now time.Time) (requestUrl string, body string, err error) {
+	requestUrl = url
+	if secret != "" {
+		timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "\n" + secret))
+		sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if requestUrl, err = addQuery(url, "timestamp", timestamp, "sign", sign); err != nil {
+			return
+		}
+	}
+	body, err = chatbotBody(content, "msgtype", map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": content},
+	})
+	return
+}
+
+// 企业微信: 地址中的 key 即为凭证, 没有签名
+func formatWecom(content string, url string, secret string, now time.Time) (requestUrl string, body string, err error) {
+	body, err = chatbotBody(content, "msgtype", map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": content},
+	})
+	return url, body, err
+}
+
+// 飞书: 签名为 base64(hmac_sha256(timestamp + "\n" + secret, "")), 与秒级时间戳一起放在请求体中
+func formatFeishu(content string, url string, secret string, now time.Time) (requestUrl string, body string, err error) {
+	var message map[string]interface{}
+	if e := json.Unmarshal([]byte(content), &message); e != nil || message["msg_type"] == nil {
+		message = map[string]interface{}{
+			"msg_type": "text",
+			"content":  map[string]string{"text": content},
+		}
+	}
+	if secret != "" {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+		message["timestamp"] = timestamp
+		message["sign"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	var encoded []byte
+	if encoded, err = json.Marshal(message); err != nil {
+		return
+	}
+	return url, string(encoded), nil
+}
+
+// Slack: 地址即为凭证, 没有签名
+func formatSlack(content string, url string, secret string, now time.Time) (requestUrl string, body string, err error) {
+	var message map[string]interface{}
+	if e := json.Unmarshal([]byte(content), &message); e == nil && (message["text"] != nil || message["blocks"] != nil) {
+		return url, content, nil
+	}
+	var encoded []byte
+	if encoded, err = json.Marshal(map[string]string{"text": content}); err != nil {
+		return
+	}
+	return url, string(encoded), nil
+}
+
+// content 是包含 typeField 的 json 对象时原样发送, 否则发送 text
+func chatbotBody(content string, typeField string, text map[string]interface{}) (body string, err error) {
+	var message map[string]interface{}
+	if e := json.Unmarshal([]byte(content), &message); e == nil && message[typeField] != nil {
+		return content, nil
+	}
+	var encoded []byte
+	if encoded, err = json.Marshal(text); err != nil {
+		return
+	}
+	return string(encoded), nil
+}
+
+func addQuery(url string, pairs ...string) (string, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		query.Set(pairs[i], pairs[i+1])
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// 返回 {"errcode": 0, "errmsg": "ok"}
+func checkDingtalk(statusCode int, result string) (string, error) {
+	return checkErrcode(statusCode, result, "errcode", "errmsg", DINGTALK_TOO_FAST)
+}
+
+// 返回 {"errcode": 0, "errmsg": "ok"}
+func checkWecom(statusCode int, result string) (string, error) {
+	return checkErrcode(statusCode, result, "errcode", "errmsg", WECOM_TOO_FAST)
+}
+
+// 返回 {"code": 0, "msg": "success"}, 旧版本为 {"StatusCode": 0, "StatusMessage": "success"}
+func checkFeishu(statusCode int, result string) (string, error) {
+	if data, err := decodeObject(result); err == nil && data["code"] == nil && data["StatusCode"] != nil {
+		return checkErrcode(statusCode, result, "StatusCode", "StatusMessage", FEISHU_TOO_FAST)
+	}
+	return checkErrcode(statusCode, result, "code", "msg", FEISHU_TOO_FAST)
+}
+
+// 返回 http 200 和 ok, 错误时为 4xx 和错误名称 (invalid_token, channel_not_found 等)
+func checkSlack(statusCode int, result string) (string, error) {
+	switch {
+	case statusCode == http.StatusOK:
+		return SEND_DELIVERED, nil
+	case statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return SEND_RETRY, nil
+	case statusCode >= 400:
+		return SEND_PERMANENT, permanent(fmt.Errorf("status_code=%d, error=%s", statusCode, result))
+	}
+	return SEND_RETRY, nil
+}
+
+// 返回 json 中 field 为 0 时成功, 为 tooFast 时重试, 其他错误码不再重试, 错误中带上错误码和 msgField 的错误信息
+// http 状态码为 429 或 5xx, 或返回不是 json 时重试
+func checkErrcode(statusCode int, result string, field string, msgField string, tooFast int64) (string, error) {
+	if statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		return SEND_RETRY, nil
+	}
+	data, err := decodeObject(result)
+	if err != nil {
+		return SEND_RETRY, nil
+	}
+	code, ok := data[field].(json.Number)
+	if !ok {
+		return SEND_RETRY, nil
+	}
+	n, err := code.Int64()
+	switch {
+	case err != nil:
+		return SEND_RETRY, nil
+	case n == 0:
+		return SEND_DELIVERED, nil
+	case n == tooFast:
+		return SEND_RETRY, nil
+	}
+	return SEND_PERMANENT, permanent(fmt.Errorf("%s=%d, %s=%v", field, n, msgField, data[msgField]))
+}
@@ -0,0 +1,121 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatChatbot(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1500000000, 0)
+
+	url, body, err := formatDingtalk("hello", "https://oapi.dingtalk.com/robot/send?access_token=T", "SEC", now)
+	assert.Nil(err)
+	assert.JSONEq(`{"msgtype":"text","text":{"content":"hello"}}`, body)
+	u, _ := neturl.Parse(url)
+	assert.Equal("T", u.Query().Get("access_token"))
+	assert.Equal("1500000000000", u.Query().Get("timestamp"))
+	// echo -ne '1500000000000\nSEC' | openssl dgst -sha256 -hmac SEC -binary | base64
+	assert.Equal("qa5srh51px2SB4hJARvLOdKqIk+LCOTmcGDqVlxDBYY=", u.Query().Get("sign"))
+
+	markdown := `{"msgtype":"markdown","markdown":{"title":"t","text":"# t"}}`
+	_, body, err = formatWecom(markdown, "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=K", "", now)
+	assert.Nil(err)
+	assert.Equal(markdown, body)
+
+	_, body, err = formatFeishu("hello", "https://open.feishu.cn/open-apis/bot/v2/hook/H", "SEC", now)
+	assert.Nil(err)
+	var message map[string]interface{}
+	json.Unmarshal([]byte(body), &message)
+	assert.Equal("text", message["msg_type"])
+	assert.Equal("1500000000", message["timestamp"])
+	assert.NotEmpty(message["sign"])
+
+	_, body, err = formatSlack("hello", "https://hooks.slack.com/services/X", "", now)
+	assert.Nil(err)
+	assert.JSONEq(`{"text":"hello"}`, body)
+}
+
+func TestCheckChatbot(t *testing.T) {
+	assert := assert.New(t)
+	status := func(status string, err error) string {
+		return status
+	}
+
+	assert.Equal(SEND_DELIVERED, status(checkDingtalk(200, `{"errcode":0,"errmsg":"ok"}`)))
+	assert.Equal(SEND_RETRY, status(checkDingtalk(200, `{"errcode":130101,"errmsg":"send too fast"}`)))
+	assert.Equal(SEND_PERMANENT, status(checkDingtalk(200, `{"errcode":310000,"errmsg":"sign not match"}`)))
+	assert.Equal(SEND_RETRY, status(checkDingtalk(502, `bad gateway`)))
+	assert.Equal(SEND_RETRY, status(checkWecom(200, `{"errcode":45009}`)))
+	assert.Equal(SEND_PERMANENT, status(checkWecom(200, `{"errcode":93000,"errmsg":"invalid webhook url"}`)))
+	assert.Equal(SEND_DELIVERED, status(checkFeishu(200, `{"code":0,"msg":"success"}`)))
+	assert.Equal(SEND_DELIVERED, status(checkFeishu(200, `{"StatusCode":0,"StatusMessage":"success"}`)))
+	assert.Equal(SEND_PERMANENT, status(checkFeishu(200, `{"code":19021,"msg":"sign match fail"}`)))
+	assert.Equal(SEND_DELIVERED, status(checkSlack(200, `ok`)))
+	assert.Equal(SEND_RETRY, status(checkSlack(429, `rate_limited`)))
+	assert.Equal(SEND_PERMANENT, status(checkSlack(404, `no_service`)))
+
+	// 不再重试时带上平台的错误码和错误信息
+	_, err := checkDingtalk(200, `{"errcode":310000,"errmsg":"sign not match"}`)
+	assert.True(isPermanent(err))
+	assert.EqualError(err, "errcode=310000, errmsg=sign not match")
+	_, err = checkFeishu(200, `{"code":19021,"msg":"sign match fail"}`)
+	assert.EqualError(err, "code=19021, msg=sign match fail")
+	_, err = checkSlack(404, `no_service`)
+	assert.EqualError(err, "status_code=404, error=no_service")
+	_, err = checkWecom(200, `{"errcode":45009}`)
+	assert.Nil(err)
+}
+
+func TestChatbotChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer server.Close()
+
+	channel, ok := getChannel(CHANNEL_WECOM)
+	assert.True(ok)
+	outcome := channel.Send(context.Background(), Delivery{Content: "hello", Destination: Destination{Channel: CHANNEL_WECOM, Url: server.URL}})
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.JSONEq(`{"msgtype":"text","text":{"content":"hello"}}`, body)
+}
+
+func TestChatbotRejected(t *testing.T) {
+	assert := assert.New(t)
+
+	SetBreakerConfig(BreakerConfig{FailureThreshold: 1})
+	defer SetBreakerConfig(DEFAULT_BREAKER_CONFIG)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errcode":93000,"errmsg":"invalid webhook url"}`))
+	}))
+	defer server.Close()
+	host := urlHost(server.URL)
+	defer ResetBreaker(host)
+
+	channel, _ := getChannel(CHANNEL_WECOM)
+	outcome := channel.Send(context.Background(), Delivery{Content: "hello", Destination: Destination{Channel: CHANNEL_WECOM, Url: server.URL}})
+	assert.Equal(SEND_PERMANENT, outcome.Status)
+	assert.True(isPermanent(outcome.Err))
+	assert.EqualError(outcome.Err, "errcode=93000, errmsg=invalid webhook url")
+
+	// 平台的错误码不计为熔断失败, 同一平台的其他机器人不受影响
+	store := NewMemoryStore()
+	msg := testMessage(10, "hello", MessageMeta{Channel: CHANNEL_WECOM, Url: server.URL})
+	assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	assert.Equal(BreakerState{State: BREAKER_CLOSED}, BreakerStates()[host])
+	members, _ := store.Range("mytopic-list-dead")
+	assert.Equal([]string{"1:10:0"}, members)
+}
@@ -0,0 +1,101 @@
+package notification
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// 返回检查方式
+const (
+	CHECKER_DEFAULT     = "default" // 返回 success 或 {"code":"0000"}
+	CHECKER_STATUS      = "status"  // HTTP 状态码为 2xx
+	CHECKER_JSON_PREFIX = "json:"   // json:field=value, 返回的 json 对象中 field 等于 value, 如 json:errcode=0
+)
+
+// 检查返回, needRetry 表示通知失败需要重试
+type Checker func(statusCode int, result string) (needRetry bool)
+
+// 可以读取通知信息 (如 kafka 消息 headers) 的返回检查方式
+type DeliveryChecker func(delivery Delivery, statusCode int, result string) (needRetry bool)
+
+var (
+	checkersMu sync.RWMutex
+	checkers   = map[string]Checker{
+		CHECKER_DEFAULT: func(statusCode int, result string) bool {
+			return checkResult(result)
+		},
+		CHECKER_STATUS: func(statusCode int, result string) bool {
+			return statusCode < 200 || statusCode >= 300
+		},
+	}
+	deliveryCheckers = map[string]DeliveryChecker{}
+)
+
+// 注册返回检查方式, 同名的会被覆盖
+func RegisterChecker(name string, checker Checker) {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	checkers[name] = checker
+}
+
+// 注册可以读取通知信息的返回检查方式, 优先于同名的 Checker
+// 合并发送时 delivery 只有通知地址
+func RegisterDeliveryChecker(name string, checker DeliveryChecker) {
+	checkersMu.Lock()
+	defer checkersMu.Unlock()
+	deliveryCheckers[name] = checker
+}
+
+func getDeliveryChecker(name string) DeliveryChecker {
+	checkersMu.RLock()
+	checker, ok := deliveryCheckers[name]
+	checkersMu.RUnlock()
+	if ok {
+		return checker
+	}
+	plain := getChecker(name)
+	return func(delivery Delivery, statusCode int, result string) bool {
+		return plain(statusCode, result)
+	}
+}
+
+// 按名称取得返回检查方式, 未知的名称使用默认方式
+func getChecker(name string) Checker {
+	fn := "getChecker"
+
+	if name == "" {
+		name = CHECKER_DEFAULT
+	}
+	if strings.HasPrefix(name, CHECKER_JSON_PREFIX) {
+		return jsonFieldChecker(strings.TrimPrefix(name, CHECKER_JSON_PREFIX))
+	}
+
+	checkersMu.RLock()
+	defer checkersMu.RUnlock()
+	if checker, ok := checkers[name]; ok {
+		return checker
+	}
+	glog.Warningf("@%s, unknown checker, use default, name=%s", fn, name)
+	return checkers[CHECKER_DEFAULT]
+}
+
+func jsonFieldChecker(expr string) Checker {
+	field, value := expr, ""
+	if i := strings.Index(expr, "="); i >= 0 {
+		field, value = expr[:i], expr[i+1:]
+	}
+	return func(statusCode int, result string) bool {
+		data, err := decodeObject(result)
+		if err != nil {
+			return true
+		}
+		v, ok := data[field]
+		if !ok || v == nil {
+			return true
+		}
+		return fmt.Sprint(v) != value
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	notification ".."
+
+	"encoding/json"
+	"time"
+)
+
+// 构造消息
+//
+//	message, err := client.NewMessage(`{"foo":"bar"}`).
+//		Url("https://api.example.com/notify").
+//		Header("dealer-id", "123").
+//		Encoding(notification.ENCODING_FORM).
+//		Ttl(24 * time.Hour).
+//		Build()
+type Builder struct {
+	message notification.Message
+	headers map[string]string
+}
+
+func NewMessage(content string) *Builder {
+	return &Builder{message: notification.Message{Content: content}}
+}
+
+// 指定 id, 不指定时由 Publisher 生成
+func (b *Builder) Id(id string) *Builder {
+	b.message.Meta.Id = id
+	return b
+}
+
+func (b *Builder) Url(url string) *Builder {
+	b.message.Meta.Url = url
+	return b
+}
+
+func (b *Builder) Header(key string, value string) *Builder {
+	if b.headers == nil {
+		b.headers = map[string]string{}
+	}
+	b.headers[key] = value
+	return b
+}
+
+func (b *Builder) Headers(headers map[string]string) *Builder {
+	for k, v := range headers {
+		b.Header(k, v)
+	}
+	return b
+}
+
+// 请求体编码, 见 notification.ENCODING_*
+func (b *Builder) Encoding(encoding string) *Builder {
+	b.message.Meta.Encoding = encoding
+	return b
+}
+
+// 发送通道, 见 notification.CHANNEL_*
+func (b *Builder) Channel(channel string) *Builder {
+	b.message.Meta.Channel = channel
+	return b
+}
+
+// 增加一个通知地址, 指定后忽略 Url, Headers, Encoding 和 Channel
+func (b *Builder) Destination(destination notification.Destination) *Builder {
+	b.message.Meta.Destinations = append(b.message.Meta.Destinations, destination)
+	return b
+}
+
+// 按事件发送到订阅的地址
+func (b *Builder) Event(event string, tenant string) *Builder {
+	b.message.Meta.Event = event
+	b.message.Meta.Tenant = tenant
+	return b
+}
+
+func (b *Builder) DeliverAt(t time.Time) *Builder {
+	b.message.Meta.DeliverAt = t.Unix()
+	return b
+}
+
+func (b *Builder) Delay(d time.Duration) *Builder {
+	b.message.Meta.Delay = int64(d / time.Second)
+	return b
+}
+
+func (b *Builder) ExpiresAt(t time.Time) *Builder {
+	b.message.Meta.ExpiresAt = t.Unix()
+	return b
+}
+
+func (b *Builder) Ttl(d time.Duration) *Builder {
+	b.message.Meta.Ttl = int64(d / time.Second)
+	return b
+}
+
+// 生成并检查消息
+func (b *Builder) Build() (message *notification.Message, err error) {
+	message = &notification.Message{Content: b.message.Content, Meta: b.message.Meta}
+	if len(b.headers) > 0 {
+		var headers []byte
+		if headers, err = json.Marshal(b.headers); err != nil {
+			return nil, err
+		}
+		message.Meta.Headers = string(headers)
+	}
+	if err = message.Validate(); err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,167 @@
+// 通知生产者客户端
+// 使用 NewMessage 构造消息, Publisher 检查后发送到 kafka, 并自动填写消息 id
+package client
+
+import (
+	notification ".."
+
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+const (
+	E_CLOSED = "The publisher has been closed"
+)
+
+// 异步发送的结果回调, err 为 nil 表示发送成功
+type Callback func(id string, err error)
+
+type Publisher struct {
+	topic    string
+	sync     sarama.SyncProducer
+	async    sarama.AsyncProducer
+	callback Callback
+	mu       sync.RWMutex // Publish 持读锁写入 Input, Close 持写锁关闭 done, 避免写入已关闭的 Input
+	done     chan struct{}
+}
+
+// 生产者默认配置, 与 listener 读取的 kafka 版本一致
+func NewConfig() *sarama.Config {
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_0_0
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	return config
+}
+
+// 同步发送, Publish 返回时消息已写入 kafka
+func NewSyncPublisher(brokers []string, topic string) (p *Publisher, err error) {
+	producer, err := sarama.NewSyncProducer(brokers, NewConfig())
+	if err != nil {
+		return
+	}
+	return NewSyncPublisherWithProducer(producer, topic), nil
+}
+
+// 异步发送, 结果通过 callback 通知, callback 可以为 nil
+func NewAsyncPublisher(brokers []string, topic string, callback Callback) (p *Publisher, err error) {
+	producer, err := sarama.NewAsyncProducer(brokers, NewConfig())
+	if err != nil {
+		return
+	}
+	return NewAsyncPublisherWithProducer(producer, topic, callback), nil
+}
+
+func NewSyncPublisherWithProducer(producer sarama.SyncProducer, topic string) *Publisher {
+	return &Publisher{topic: topic, sync: producer}
+}
+
+// producer 需要开启 Producer.Return.Successes 和 Producer.Return.Errors
+func NewAsyncPublisherWithProducer(producer sarama.AsyncProducer, topic string, callback Callback) *Publisher {
+	p := &Publisher{topic: topic, async: producer, callback: callback, done: make(chan struct{})}
+	go p.results()
+	return p
+}
+
+// 检查并发送消息, 没有 meta.id 时生成, 返回消息 id
+// key 为 kafka 消息 key, 顺序发送的 topic 中同一 key 的消息按顺序发送, 可以为空
+func (p *Publisher) Publish(message *notification.Message, key string) (id string, err error) {
+	fn := "Publisher.Publish"
+
+	if err = message.Validate(); err != nil {
+		return
+	}
+	if message.Meta.Id == "" {
+		message.Meta.Id = NewId()
+	}
+	id = message.Meta.Id
+
+	var value []byte
+	if value, err = json.Marshal(message); err != nil {
+		return
+	}
+	msg := &sarama.ProducerMessage{Topic: p.topic, Value: sarama.ByteEncoder(value), Metadata: id}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+
+	if p.sync != nil {
+		if _, _, err = p.sync.SendMessage(msg); err != nil {
+			glog.Errorf("@%s, SendMessage failed, err=%s, topic=%s, id=%s", fn, err, p.topic, id)
+		}
+		return
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	select {
+	case <-p.done:
+		err = errors.New(E_CLOSED)
+	default:
+		p.async.Input() <- msg
+	}
+	return
+}
+
+// 关闭 producer, 异步发送时会等待已提交的消息发送完成
+func (p *Publisher) Close() (err error) {
+	if p.sync != nil {
+		return p.sync.Close()
+	}
+	p.mu.Lock()
+	select {
+	case <-p.done:
+		p.mu.Unlock()
+		return errors.New(E_CLOSED)
+	default:
+	}
+	close(p.done)
+	p.mu.Unlock()
+	return p.async.Close()
+}
+
+func (p *Publisher) results() {
+	fn := "Publisher.results"
+
+	successes, errs := p.async.Successes(), p.async.Errors()
+	for successes != nil || errs != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			if p.callback != nil {
+				p.callback(fmt.Sprint(msg.Metadata), nil)
+			}
+		case e, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			glog.Errorf("@%s, publish failed, err=%s, topic=%s, id=%v", fn, e.Err, p.topic, e.Msg.Metadata)
+			if p.callback != nil {
+				p.callback(fmt.Sprint(e.Msg.Metadata), e.Err)
+			}
+		}
+	}
+}
+
+// 生成消息 id (uuid v4)
+func NewId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	notification ".."
+
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilder(t *testing.T) {
+	assert := assert.New(t)
+
+	message, err := NewMessage(`{"foo":"bar"}`).
+		Url("http://localhost:8000/printall").
+		Header("myheaderkey", "myheadervalue").
+		Encoding(notification.ENCODING_FORM).
+		Ttl(time.Hour).
+		Build()
+	assert.Nil(err)
+	assert.Equal(`{"myheaderkey":"myheadervalue"}`, message.Meta.Headers)
+	assert.Equal(notification.ENCODING_FORM, message.Meta.Encoding)
+	assert.Equal(int64(3600), message.Meta.Ttl)
+
+	_, err = NewMessage(`{"foo":"bar"}`).Build()
+	assert.EqualError(err, notification.E_NO_DESTINATION)
+	_, err = NewMessage(`{"foo":"bar"}`).Url("not a url").Build()
+	assert.NotNil(err)
+	_, err = NewMessage(`not json`).Url("http://localhost").Encoding(notification.ENCODING_FORM).Build()
+	assert.NotNil(err)
+	_, err = NewMessage(`{"foo":"bar"}`).Url("http://localhost").Encoding("yaml").Build()
+	assert.NotNil(err)
+	_, err = NewMessage(`{"foo":"bar"}`).Destination(notification.Destination{Url: "http://localhost", Headers: `[1]`}).Build()
+	assert.NotNil(err)
+	_, err = NewMessage(`{"foo":"bar"}`).Event("order.paid", "t1").Build()
+	assert.Nil(err)
+
+	// 生产者没有配置 SMTP, 也能发送邮件通知
+	_, err = NewMessage(`{"subject":"paid"}`).Destination(notification.Destination{Channel: notification.CHANNEL_EMAIL, Url: "mailto:a@example.com"}).Build()
+	assert.Nil(err)
+	_, err = NewMessage(`{"subject":"paid"}`).Destination(notification.Destination{Channel: notification.CHANNEL_EMAIL, Url: "http://localhost"}).Build()
+	assert.NotNil(err)
+}
+
+func TestSyncPublisher(t *testing.T) {
+	assert := assert.New(t)
+
+	var sent notification.Message
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+		return json.Unmarshal(value, &sent)
+	})
+	producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+	publisher := NewSyncPublisherWithProducer(producer, "mytopic")
+	defer publisher.Close()
+
+	message, _ := NewMessage(`{"foo":"bar"}`).Url("http://localhost:8000/printall").Build()
+	id, err := publisher.Publish(message, "RECHARGE_SUCCESS")
+	assert.Nil(err)
+	assert.Len(id, 36)
+	assert.Equal(id, sent.Meta.Id)
+	assert.Equal(`{"foo":"bar"}`, sent.Content)
+
+	message, _ = NewMessage(`{"foo":"bar"}`).Id("order-1").Url("http://localhost:8000/printall").Build()
+	id, err = publisher.Publish(message, "")
+	assert.Equal("order-1", id)
+	assert.Equal(sarama.ErrNotEnoughReplicas, err)
+
+	// 无效的消息不发送
+	_, err = publisher.Publish(&notification.Message{Content: "{}"}, "")
+	assert.NotNil(err)
+}
+
+func TestAsyncPublisher(t *testing.T) {
+	assert := assert.New(t)
+
+	config := NewConfig()
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(errors.New("broker down"))
+
+	results := make(chan error, 2)
+	ids := make(chan string, 2)
+	publisher := NewAsyncPublisherWithProducer(producer, "mytopic", func(id string, err error) {
+		ids <- id
+		results <- err
+	})
+
+	message, _ := NewMessage(`{"foo":"bar"}`).Id("a").Url("http://localhost:8000/printall").Build()
+	_, err := publisher.Publish(message, "")
+	assert.Nil(err)
+	assert.Equal("a", <-ids)
+	assert.Nil(<-results)
+
+	message, _ = NewMessage(`{"foo":"bar"}`).Id("b").Url("http://localhost:8000/printall").Build()
+	_, err = publisher.Publish(message, "")
+	assert.Nil(err)
+	assert.Equal("b", <-ids)
+	assert.EqualError(<-results, "broker down")
+
+	assert.Nil(publisher.Close())
+	_, err = publisher.Publish(message, "")
+	assert.EqualError(err, E_CLOSED)
+}
+
+// 关闭时关闭 Input 的 producer, 与 sarama 的 AsyncProducer 相同
+type closingProducer struct {
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	stopped   chan struct{}
+}
+
+func newClosingProducer() *closingProducer {
+	p := &closingProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+		stopped:   make(chan struct{}),
+	}
+	go func() {
+		defer close(p.stopped)
+		for msg := range p.input {
+			p.successes <- msg
+		}
+		close(p.successes)
+		close(p.errors)
+	}()
+	return p
+}
+
+func (p *closingProducer) AsyncClose()                               { close(p.input) }
+func (p *closingProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *closingProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+
+// 稍后返回 Input, 使 Close 有机会在检查 done 和写入 Input 之间关闭
+func (p *closingProducer) Input() chan<- *sarama.ProducerMessage {
+	time.Sleep(time.Millisecond)
+	return p.input
+}
+
+func (p *closingProducer) Close() error {
+	p.AsyncClose()
+	<-p.stopped
+	return nil
+}
+
+func TestAsyncPublisherClose(t *testing.T) {
+	assert := assert.New(t)
+
+	// 关闭时正在发送的消息不会写入已关闭的 Input, 之后的消息返回 E_CLOSED
+	for round := 0; round < 20; round++ {
+		publisher := NewAsyncPublisherWithProducer(newClosingProducer(), "mytopic", nil)
+		var wg sync.WaitGroup
+		started := make(chan struct{}, 4)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				started <- struct{}{}
+				for j := 0; j < 50; j++ {
+					message, _ := NewMessage(`{"foo":"bar"}`).Url("http://localhost:8000/printall").Build()
+					if _, err := publisher.Publish(message, ""); err != nil {
+						assert.EqualError(err, E_CLOSED)
+						return
+					}
+				}
+			}()
+		}
+		for i := 0; i < 4; i++ {
+			<-started
+		}
+		assert.Nil(publisher.Close())
+		wg.Wait()
+		assert.EqualError(publisher.Close(), E_CLOSED)
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	notification ".."
+
+	"fmt"
+	"time"
+)
+
+// 按配置设置 notification, 实时处理程序和重试程序启动时调用
+// serviceName 为链路追踪的服务名称, brokers 为 -brokers 指定的集群, 状态事件没有配置 brokers 时使用
+// 合并发送只在首次发送时使用, 重试程序中设置不起作用
+func (c Config) Apply(serviceName string, brokers []string) (err error) {
+	logLevel, err := notification.ParseLogLevel(c.Log.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log config: %s", err)
+	}
+	logger, err := notification.OpenLogger(c.Log.Output, notification.LogConfig{
+		Level:         logLevel,
+		RedactHeaders: c.Log.Redactheaders,
+		RedactFields:  c.Log.Redactfields,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open log output: %s", err)
+	}
+	notification.SetLogger(logger)
+	if err = notification.SetTracing(notification.TracingConfig{
+		Exporter:    c.Tracing.Exporter,
+		Endpoint:    c.Tracing.Endpoint,
+		Insecure:    c.Tracing.Insecure,
+		ServiceName: serviceName,
+		SampleRatio: c.Tracing.Sampleratio,
+	}); err != nil {
+		return fmt.Errorf("failed to start tracing: %s", err)
+	}
+
+	notification.ExpiredToDeadLetter = c.Expiry.Deadletter
+	notification.SetBreakerConfig(notification.BreakerConfig{
+		FailureThreshold: c.Breaker.FailureThreshold,
+		OpenTimeout:      time.Duration(c.Breaker.OpenTimeout) * time.Second,
+		HalfOpenRequests: c.Breaker.HalfOpenRequests,
+	})
+	var rateLimits []notification.RateLimit
+	for _, rule := range c.Ratelimit.Rules {
+		rateLimits = append(rateLimits, notification.RateLimit{Prefix: rule.Prefix, Rate: rule.Rate, Burst: rule.Burst})
+	}
+	notification.SetRateLimits(rateLimits, time.Duration(c.Ratelimit.MaxWait)*time.Second)
+	notification.SetOrderedTopics(c.Ordered.Topics)
+	var timeoutRules []notification.TimeoutRule
+	for _, rule := range c.Timeout.Rules {
+		timeoutRules = append(timeoutRules, notification.TimeoutRule{Prefix: rule.Prefix, Timeouts: notification.Timeouts{
+			Connect:        rule.Connect,
+			TlsHandshake:   rule.Tlshandshake,
+			ResponseHeader: rule.Responseheader,
+			Total:          rule.Total,
+		}})
+	}
+	notification.SetTimeoutRules(timeoutRules)
+	notification.SetResponseConfig(notification.ResponseConfig{
+		MaxBytes:   c.Response.Maxbytes,
+		AuditBytes: c.Response.Auditbytes,
+	})
+	notification.SetRetryPolicy(notification.RetryPolicy{
+		MaxTries:   c.Inline.Maxtries,
+		Initial:    time.Duration(c.Inline.Initial) * time.Millisecond,
+		Max:        time.Duration(c.Inline.Max) * time.Millisecond,
+		Multiplier: c.Inline.Multiplier,
+		Jitter:     c.Inline.Jitter,
+		Budget:     time.Duration(c.Inline.Budget) * time.Millisecond,
+		Statuses:   c.Inline.Statuses,
+	})
+	var headerMappings []notification.HeaderMapping
+	for _, mapping := range c.Headers.Mappings {
+		headerMappings = append(headerMappings, notification.HeaderMapping{Kafka: mapping.Kafka, Http: mapping.Http})
+	}
+	notification.SetHeaderConfig(notification.HeaderConfig{
+		Mappings: headerMappings,
+		Event:    c.Headers.Event,
+		Tenant:   c.Headers.Tenant,
+		Channel:  c.Headers.Channel,
+		Read:     c.Headers.Read,
+	})
+	if err = notification.SetGrpcConfig(notification.GrpcConfig{
+		Timeout:            time.Duration(c.Grpc.Timeout) * time.Second,
+		CaFile:             c.Grpc.Cafile,
+		InsecureSkipVerify: c.Grpc.Insecureskipverify,
+	}); err != nil {
+		return fmt.Errorf("failed to set grpc config: %s", err)
+	}
+	var forwardClusters []notification.ForwardCluster
+	for _, cluster := range c.Forward.Clusters {
+		forwardClusters = append(forwardClusters, notification.ForwardCluster{Name: cluster.Name, Brokers: cluster.Brokers})
+	}
+	notification.SetForwardClusters(forwardClusters)
+	notification.SetAdminKeys(c.Admin.Keys)
+	statusBrokers := c.Status.Brokers
+	if len(statusBrokers) == 0 {
+		statusBrokers = brokers
+	}
+	if err = notification.SetStatusTopic(statusBrokers, c.Status.Topic); err != nil {
+		return fmt.Errorf("failed to start status producer: %s", err)
+	}
+	if smtp := c.Smtp; smtp.Host != "" {
+		notification.RegisterChannel(notification.CHANNEL_EMAIL, notification.NewSmtpChannel(notification.SmtpConfig{
+			Host:     smtp.Host,
+			Port:     smtp.Port,
+			Starttls: smtp.Starttls,
+			Username: smtp.Username,
+			Password: smtp.Password,
+			From:     smtp.From,
+			Timeout:  time.Duration(smtp.Timeout) * time.Second,
+		}))
+	}
+	var batchRules []notification.BatchRule
+	for _, rule := range c.Batch.Rules {
+		batchRules = append(batchRules, notification.BatchRule{
+			Prefix:  rule.Prefix,
+			MaxSize: rule.MaxSize,
+			MaxWait: time.Duration(rule.MaxWait) * time.Millisecond,
+			Format:  rule.Format,
+		})
+	}
+	if err = notification.SetBatchRules(batchRules); err != nil {
+		return fmt.Errorf("invalid batch config: %s", err)
+	}
+	return
+}
@@ -35,7 +35,24 @@ func load(path string) (err error) {
 }
 
 type Config struct {
-	Redis Redis
+	Redis     Redis
+	Expiry    Expiry
+	Breaker   Breaker
+	Ratelimit Ratelimit
+	Ordered   Ordered
+	Batch     Batch
+	Smtp      Smtp
+	Grpc      Grpc
+	Forward   Forward
+	Status    Status
+	Ingest    Ingest
+	Admin     Admin
+	Log       Log
+	Tracing   Tracing
+	Headers   Headers
+	Timeout   Timeout
+	Inline    Inline
+	Response  Response
 }
 
 type Redis struct {
@@ -47,6 +64,142 @@ type Redis struct {
 	Protocol  string
 }
 
+type Expiry struct {
+	Deadletter bool // 过期的通知是否放入死信列表
+}
+
+type Breaker struct {
+	FailureThreshold int // 连续失败多少次后熔断, 0 表示不熔断
+	OpenTimeout      int // 熔断多少秒后进入半开状态, 0 表示 60
+	HalfOpenRequests int // 半开状态下同时允许的试探请求数, 0 表示 1
+}
+
+type Ratelimit struct {
+	MaxWait int // 超过限流时最多等待的秒数, 超过则放入延迟队列
+	Rules   []RatelimitRule
+}
+
+type RatelimitRule struct {
+	Prefix string  // host 或 URL 前缀
+	Rate   float64 // 每秒请求数
+	Burst  int     // 允许的突发请求数
+}
+
+type Ordered struct {
+	Topics  []string // 按 kafka 消息 key 顺序发送的 topic
+	Workers int      // 实时处理程序中顺序发送的协程数, 同一 key 的消息由同一个协程依次发送
+}
+
+type Batch struct {
+	Rules []BatchRule
+}
+
+type BatchRule struct {
+	Prefix  string // host 或 URL 前缀
+	MaxSize int    // 达到该数量时立即发送
+	MaxWait int    // 第一条通知最多等待的毫秒数, 必须大于 0
+	Format  string // json (数组) 或 ndjson
+}
+
+type Smtp struct {
+	Host     string // 为空时不开启邮件通道
+	Port     int
+	Starttls bool
+	Username string // 为空时不认证
+	Password string
+	From     string
+	Timeout  int // 连接和发送的超时秒数
+}
+
+type Grpc struct {
+	Timeout            int    // 每次调用的超时秒数
+	Cafile             string // grpcs 使用的 CA 证书, 为空时使用系统证书
+	Insecureskipverify bool   // grpcs 不校验证书, 只用于测试
+}
+
+type Forward struct {
+	Clusters []ForwardCluster
+}
+
+type ForwardCluster struct {
+	Name    string // 通知地址 kafka://<name>/<topic> 中的集群名称
+	Brokers []string
+}
+
+type Status struct {
+	Topic   string   // 状态事件的 topic, 为空时不发送
+	Brokers []string // 为空时使用 -brokers 指定的集群
+}
+
+type Ingest struct {
+	Keys     []IngestKey
+	Maxbytes int64 // 请求体最大字节数
+	Maxbatch int   // 一次最多通知数
+}
+
+type IngestKey struct {
+	Name string // 生产者名称, 记录在日志中
+	Key  string // 请求头 X-Api-Key 或 Authorization: Bearer
+}
+
+type Admin struct {
+	Keys []string // 管理接口的 api key (请求头 X-Api-Key 或 Authorization: Bearer), 为空时订阅接口不可用
+}
+
+type Log struct {
+	Level         string   // error, warning, info 或 debug, debug 时输出请求体和响应体
+	Output        string   // 为空时输出到 glog, 或 stdout, stderr, 文件路径
+	Redactheaders []string // 隐藏的 header
+	Redactfields  []string // 隐藏的请求体和响应体字段
+}
+
+type Tracing struct {
+	Exporter    string  // otlp 或 stdout, 为空时不开启
+	Endpoint    string  // otlp 的地址, 如 localhost:4317
+	Insecure    bool    // otlp 不使用 TLS
+	Sampleratio float64 // 采样比例, 0 到 1
+}
+
+type Headers struct {
+	Mappings []HeaderMapping
+	Event    string // 作为 meta.event 的 kafka header
+	Tenant   string // 作为 meta.tenant 的 kafka header
+	Channel  string // 作为 meta.channel 的 kafka header
+	Read     bool   // 没有以上规则时也读取 kafka headers
+}
+
+type HeaderMapping struct {
+	Kafka string // kafka header 名称
+	Http  string // 发送时的 header 名称, 为空时与 kafka 相同
+}
+
+type Timeout struct {
+	Rules []TimeoutRule
+}
+
+type TimeoutRule struct {
+	Prefix         string // host 或 URL 前缀
+	Connect        int64  // 建立连接的超时毫秒数
+	Tlshandshake   int64  // TLS 握手的超时毫秒数
+	Responseheader int64  // 等待返回 header 的超时毫秒数
+	Total          int64  // 一次发送的超时毫秒数
+}
+
+type Inline struct {
+	Maxtries   int     // 最多发送次数, 包括第一次
+	Initial    int     // 第一次重试前等待的毫秒数
+	Max        int     // 最长等待的毫秒数
+	Multiplier float64 // 每次等待是上一次的倍数
+	Jitter     float64 // 等待时间随机增减的比例
+	Budget     int     // 发送和等待的总毫秒数
+	Statuses   []int   // 立即重试的 http 状态码
+}
+
+type Response struct {
+	Maxbytes   int64 // 最多读取的返回内容字节数
+	Auditbytes int   // 日志和状态事件中保留的返回内容字节数
+}
+
 func printErrorAndExit(code int, format string, values ...interface{}) {
 	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
 	fmt.Fprintln(os.Stderr)
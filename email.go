@@ -0,0 +1,260 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	CHANNEL_EMAIL = "email" // 邮件, 通知地址为 mailto:a@example.com,b@example.com
+
+	DEFAULT_EMAIL_SUBJECT = "Notification"
+
+	E_NOT_MAILTO          = "The url of email destination must be mailto:address[,address]"
+	E_SMTP_NOT_CONFIGURED = "SMTP is not configured"
+)
+
+// 没有配置 SMTP 的进程 (如生产者和接入程序) 也能检查邮件通知地址, 启动程序配置 SMTP 后替换为 NewSmtpChannel
+func init() {
+	channels[CHANNEL_EMAIL] = unconfiguredEmailChannel{}
+}
+
+// SMTP 配置
+type SmtpConfig struct {
+	Host     string
+	Port     int
+	Starttls bool // 连接后使用 STARTTLS
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration // 连接和发送的超时时间, 0 表示 30 秒
+}
+
+// 邮件内容: Content 为 json 对象 {"subject": "...", "text": "...", "html": "..."}
+// 不是 json 对象时以 Content 为纯文本正文, 使用默认主题
+type emailContent struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	Html    string `json:"html"`
+}
+
+// 邮件发送通道
+type smtpChannel struct {
+	config SmtpConfig
+}
+
+func NewSmtpChannel(config SmtpConfig) Channel {
+	if config.Port == 0 {
+		config.Port = 25
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+	return &smtpChannel{config: config}
+}
+
+func (c *smtpChannel) Check(destination Destination) (err error) {
+	_, err = mailtoAddresses(destination.Url)
+	return
+}
+
+// 没有配置 SMTP 时的邮件通道: 只检查通知地址, 发送时重试, 由配置了 SMTP 的重试程序发送
+type unconfiguredEmailChannel struct{}
+
+func (unconfiguredEmailChannel) Check(destination Destination) (err error) {
+	_, err = mailtoAddresses(destination.Url)
+	return
+}
+
+func (unconfiguredEmailChannel) Send(ctx context.Context, delivery Delivery) Outcome {
+	return Outcome{Status: SEND_RETRY, Err: errors.New(E_SMTP_NOT_CONFIGURED)}
+}
+
+// 发送一次, SMTP 返回 5xx (如收件人不存在) 时不再重试, 其他错误重试
+func (c *smtpChannel) Send(ctx context.Context, delivery Delivery) (outcome Outcome) {
+	fn := "smtpChannel.Send"
+
+	outcome.Status = SEND_RETRY
+	to, err := mailtoAddresses(delivery.Destination.Url)
+	if err != nil {
+		outcome.Status = SEND_PERMANENT
+		outcome.Err = err
+		return
+	}
+	data, err := renderEmail(c.config.From, to, delivery.Content)
+	if err != nil {
+		outcome.Status = SEND_PERMANENT
+		outcome.Err = err
+		return
+	}
+
+	if err = c.sendMail(ctx, to, data); err != nil {
+		glog.Errorf("@%s, sendMail failed, err=%s, to=%v", fn, err, to)
+		outcome.Err = err
+		if e, ok := err.(*textproto.Error); ok {
+			outcome.StatusCode = e.Code
+			outcome.Result = e.Msg
+			if e.Code >= 500 {
+				outcome.Status = SEND_PERMANENT
+			}
+		}
+		return
+	}
+	outcome.Status = SEND_DELIVERED
+	outcome.Result = "sent"
+	return
+}
+
+func (c *smtpChannel) sendMail(ctx context.Context, to []string, data []byte) (err error) {
+	addr := net.JoinHostPort(c.config.Host, strconv.Itoa(c.config.Port))
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, c.config.Host)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer client.Close()
+
+	if c.config.Starttls {
+		if err = client.StartTLS(&tls.Config{ServerName: c.config.Host}); err != nil {
+			return
+		}
+	}
+	if c.config.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", c.config.Username, c.config.Password, c.config.Host)); err != nil {
+			return
+		}
+	}
+	if err = client.Mail(c.config.From); err != nil {
+		return
+	}
+	for _, address := range to {
+		if err = client.Rcpt(address); err != nil {
+			return
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(data); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return client.Quit()
+}
+
+// mailto:a@example.com,b@example.com 中的收件人
+func mailtoAddresses(url string) (to []string, err error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "mailto" || u.Opaque == "" {
+		err = errors.New(E_NOT_MAILTO)
+		return
+	}
+	list, err := neturl.PathUnescape(u.Opaque)
+	if err != nil {
+		return
+	}
+	addresses, err := mail.ParseAddressList(list)
+	if err != nil {
+		return
+	}
+	for _, address := range addresses {
+		to = append(to, address.Address)
+	}
+	return
+}
+
+// 生成邮件, 同时有 text 和 html 时为 multipart/alternative
+func renderEmail(from string, to []string, content string) (data []byte, err error) {
+	var email emailContent
+	if e := json.Unmarshal([]byte(content), &email); e != nil || (email.Text == "" && email.Html == "") {
+		email = emailContent{Text: content}
+	}
+	if email.Subject == "" {
+		email.Subject = DEFAULT_EMAIL_SUBJECT
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if email.Text != "" && email.Html != "" {
+		w := multipart.NewWriter(&buf)
+		fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=utf-8", email.Text},
+			{"text/html; charset=utf-8", email.Html},
+		} {
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Type", part.contentType)
+			header.Set("Content-Transfer-Encoding", "quoted-printable")
+			var pw io.Writer
+			if pw, err = w.CreatePart(header); err != nil {
+				return
+			}
+			if err = writeQuotedPrintable(pw, part.body); err != nil {
+				return
+			}
+		}
+		if err = w.Close(); err != nil {
+			return
+		}
+		return buf.Bytes(), nil
+	}
+
+	contentType, body := "text/plain; charset=utf-8", email.Text
+	if email.Html != "" {
+		contentType, body = "text/html; charset=utf-8", email.Html
+	}
+	fmt.Fprintf(&buf, "Content-Type: %s\r\n", contentType)
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	if err = writeQuotedPrintable(&buf, body); err != nil {
+		return
+	}
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) (err error) {
+	qw := quotedprintable.NewWriter(w)
+	if _, err = qw.Write([]byte(body)); err != nil {
+		return
+	}
+	return qw.Close()
+}
@@ -0,0 +1,151 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 进程内的 SMTP 服务, 收件人为 reject@ 开头时返回 550
+type smtpStub struct {
+	listener net.Listener
+	messages chan string
+}
+
+func newSmtpStub(t *testing.T) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener, messages: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	return stub
+}
+
+func (s *smtpStub) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 stub ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 stub")
+		case strings.HasPrefix(cmd, "MAIL FROM"):
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "RCPT TO:<REJECT@"):
+			reply("550 mailbox unavailable")
+		case strings.HasPrefix(cmd, "RCPT TO"):
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.messages <- data.String()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSmtpChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	stub := newSmtpStub(t)
+	defer stub.listener.Close()
+	channel := NewSmtpChannel(SmtpConfig{Host: "127.0.0.1", Port: stub.port(), From: "notification@example.com"})
+
+	assert.Nil(channel.Check(Destination{Url: "mailto:a@example.com,b@example.com"}))
+	assert.NotNil(channel.Check(Destination{Url: "http://a.com"}))
+
+	outcome := channel.Send(context.Background(), Delivery{
+		Content:     `{"subject":"结算通知","text":"hello","html":"<b>hello</b>"}`,
+		Destination: Destination{Channel: CHANNEL_EMAIL, Url: "mailto:a@example.com"},
+	})
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.Nil(outcome.Err)
+	data := <-stub.messages
+	assert.Contains(data, "To: a@example.com\r\n")
+	assert.Contains(data, "Subject: =?utf-8?q?")
+	assert.Contains(data, "multipart/alternative")
+	assert.Contains(data, "<b>hello</b>")
+
+	outcome = channel.Send(context.Background(), Delivery{
+		Content:     "plain",
+		Destination: Destination{Channel: CHANNEL_EMAIL, Url: "mailto:reject@example.com"},
+	})
+	assert.Equal(SEND_PERMANENT, outcome.Status)
+	assert.Equal(550, outcome.StatusCode)
+
+	// 连接失败时重试
+	closed := NewSmtpChannel(SmtpConfig{Host: "127.0.0.1", Port: stub.port()})
+	stub.listener.Close()
+	outcome = closed.Send(context.Background(), Delivery{Content: "plain", Destination: Destination{Url: "mailto:a@example.com"}})
+	assert.Equal(SEND_RETRY, outcome.Status)
+	assert.NotNil(outcome.Err)
+}
+
+func TestUnconfiguredEmailChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	channel := unconfiguredEmailChannel{}
+	assert.Nil(channel.Check(Destination{Url: "mailto:a@example.com"}))
+	assert.NotNil(channel.Check(Destination{Url: "http://a.com"}))
+	outcome := channel.Send(context.Background(), Delivery{Content: "plain", Destination: Destination{Url: "mailto:a@example.com"}})
+	assert.Equal(SEND_RETRY, outcome.Status)
+	assert.EqualError(outcome.Err, E_SMTP_NOT_CONFIGURED)
+}
+
+func TestRenderEmail(t *testing.T) {
+	assert := assert.New(t)
+
+	data, err := renderEmail("from@example.com", []string{"a@example.com", "b@example.com"}, "not json")
+	assert.Nil(err)
+	email := string(data)
+	assert.Contains(email, "To: a@example.com, b@example.com\r\n")
+	assert.Contains(email, "Subject: "+DEFAULT_EMAIL_SUBJECT+"\r\n")
+	assert.Contains(email, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.True(strings.HasSuffix(email, "\r\n\r\nnot json"))
+
+	to, err := mailtoAddresses("mailto:a@example.com,%20b@example.com")
+	assert.Nil(err)
+	assert.Equal([]string{"a@example.com", "b@example.com"}, to)
+	assert.Equal("mailto", urlHost("mailto:a@example.com"))
+}
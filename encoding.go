@@ -0,0 +1,264 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	neturl "net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	ENCODING_JSON      = "json"
+	ENCODING_FORM      = "form"
+	ENCODING_MULTIPART = "multipart"
+	ENCODING_XML       = "xml"
+	ENCODING_TEXT      = "text"
+	ENCODING_BINARY    = "binary"
+	ENCODING_NDJSON    = "ndjson"
+
+	E_UNKNOWN_ENCODING = "Unknown encoding"
+	E_NOT_JSON_OBJECT  = "The content is not a json object"
+)
+
+// multipart 编码时 content 的格式, 文件内容使用 base64 携带
+// {"fields": {"a": "1", "b": {"c": "2"}}, "files": [{"field": "file", "filename": "a.pdf", "content_type": "application/pdf", "data": "JVBERi0..."}]}
+type multipartContent struct {
+	Fields map[string]interface{} `json:"fields"`
+	Files  []multipartFile        `json:"files"`
+}
+
+type multipartFile struct {
+	Field       string `json:"field"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Data        string `json:"data"`
+}
+
+// 按照 encoding 将 content 编码为请求体, 并返回对应的 Content-Type
+// contentType 为 headers 中自定义的 Content-Type, 除 multipart (需要 boundary) 外均优先使用
+// 未指定 encoding 时沿用旧的行为: 自定义 application/x-www-form-urlencoded 按表单编码, 否则按 json 发送
+func encodeBody(content string, encoding string, contentType string) (body []byte, ct string, err error) {
+	if encoding == "" {
+		encoding = ENCODING_JSON
+		if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+			encoding = ENCODING_FORM
+		}
+	}
+
+	switch encoding {
+	case ENCODING_JSON:
+		body, ct = []byte(content), "application/json"
+	case ENCODING_FORM:
+		var values neturl.Values
+		if values, err = encodeForm(content); err != nil {
+			return
+		}
+		body, ct = []byte(values.Encode()), "application/x-www-form-urlencoded"
+	case ENCODING_MULTIPART:
+		body, ct, err = encodeMultipart(content)
+		return
+	case ENCODING_XML:
+		if body, err = encodeXml(content); err != nil {
+			return
+		}
+		ct = "application/xml; charset=utf-8"
+	case ENCODING_TEXT:
+		body, ct = []byte(content), "text/plain; charset=utf-8"
+	case ENCODING_NDJSON:
+		body, ct = []byte(content), "application/x-ndjson"
+	case ENCODING_BINARY:
+		if body, err = base64.StdEncoding.DecodeString(content); err != nil {
+			return
+		}
+		ct = "application/octet-stream"
+	default:
+		err = fmt.Errorf("%s: %s", E_UNKNOWN_ENCODING, encoding)
+		return
+	}
+
+	if contentType != "" {
+		ct = contentType
+	}
+	return
+}
+
+// 将 json 对象转换为表单, 嵌套字段展开为 a[b][c]=v, 数组展开为 a[0]=v
+func encodeForm(content string) (values neturl.Values, err error) {
+	var data map[string]interface{}
+	if data, err = decodeObject(content); err != nil {
+		return
+	}
+	values = neturl.Values{}
+	for key, val := range data {
+		flattenForm(values, key, val)
+	}
+	return
+}
+
+func flattenForm(values neturl.Values, prefix string, val interface{}) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, sub := range v {
+			flattenForm(values, prefix+"["+key+"]", sub)
+		}
+	case []interface{}:
+		for i, sub := range v {
+			flattenForm(values, fmt.Sprintf("%s[%d]", prefix, i), sub)
+		}
+	default:
+		values.Add(prefix, scalarString(v))
+	}
+}
+
+func encodeMultipart(content string) (body []byte, ct string, err error) {
+	var data multipartContent
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err = decoder.Decode(&data); err != nil {
+		return
+	}
+
+	fields := neturl.Values{}
+	for key, val := range data.Fields {
+		flattenForm(fields, key, val)
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	for _, key := range keys {
+		for _, val := range fields[key] {
+			if err = writer.WriteField(key, val); err != nil {
+				return
+			}
+		}
+	}
+	for _, file := range data.Files {
+		var raw []byte
+		if raw, err = base64.StdEncoding.DecodeString(file.Data); err != nil {
+			return
+		}
+		fileType := file.ContentType
+		if fileType == "" {
+			fileType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.Field), escapeQuotes(file.Filename)))
+		h.Set("Content-Type", fileType)
+		var part io.Writer
+		if part, err = writer.CreatePart(h); err != nil {
+			return
+		}
+		if _, err = part.Write(raw); err != nil {
+			return
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+
+	body, ct = buf.Bytes(), writer.FormDataContentType()
+	return
+}
+
+// 将 json 对象转换为 xml, 根节点为 <xml>, 数组展开为同名的多个节点
+func encodeXml(content string) (body []byte, err error) {
+	var data map[string]interface{}
+	if data, err = decodeObject(content); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = writeXmlElement(&buf, "xml", data); err != nil {
+		return
+	}
+	body = buf.Bytes()
+	return
+}
+
+func writeXmlElement(buf *bytes.Buffer, name string, val interface{}) (err error) {
+	if !isXmlName(name) {
+		return fmt.Errorf("invalid xml element name: %q", name)
+	}
+	if list, ok := val.([]interface{}); ok {
+		for _, item := range list {
+			if err = writeXmlElement(buf, name, item); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	buf.WriteString("<" + name + ">")
+	if obj, ok := val.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err = writeXmlElement(buf, key, obj[key]); err != nil {
+				return
+			}
+		}
+	} else if err = xml.EscapeText(buf, []byte(scalarString(val))); err != nil {
+		return
+	}
+	buf.WriteString("</" + name + ">")
+	return
+}
+
+func isXmlName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case i > 0 && (r == '-' || r == '.' || r >= '0' && r <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func decodeObject(content string) (data map[string]interface{}, err error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	if err = decoder.Decode(&data); err != nil {
+		return
+	}
+	if data == nil {
+		err = errors.New(E_NOT_JSON_OBJECT)
+	}
+	return
+}
+
+func scalarString(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,106 @@
+package notification
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	neturl "net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeBodyJson(t *testing.T) {
+	assert := assert.New(t)
+
+	body, ct, err := encodeBody(`{"foo":"bar"}`, "", "")
+	assert.Nil(err)
+	assert.Equal("application/json", ct)
+	assert.Equal(`{"foo":"bar"}`, string(body))
+
+	_, ct, err = encodeBody(`{"foo":"bar"}`, ENCODING_JSON, "application/json; charset=utf-8")
+	assert.Nil(err)
+	assert.Equal("application/json; charset=utf-8", ct)
+}
+
+func TestEncodeBodyForm(t *testing.T) {
+	assert := assert.New(t)
+
+	// 旧的写法: 通过 headers 指定 Content-Type
+	body, ct, err := encodeBody(`{"data":"abc","sign":"xyz"}`, "", "application/x-www-form-urlencoded")
+	assert.Nil(err)
+	assert.Equal("application/x-www-form-urlencoded", ct)
+	assert.Equal("data=abc&sign=xyz", string(body))
+
+	// 嵌套字段
+	body, _, err = encodeBody(`{"order":{"id":1001,"items":[{"sku":"a"},{"sku":"b"}]},"paid":true}`, ENCODING_FORM, "")
+	assert.Nil(err)
+	values, err := neturl.ParseQuery(string(body))
+	assert.Nil(err)
+	assert.Equal("1001", values.Get("order[id]"))
+	assert.Equal("a", values.Get("order[items][0][sku]"))
+	assert.Equal("b", values.Get("order[items][1][sku]"))
+	assert.Equal("true", values.Get("paid"))
+
+	_, _, err = encodeBody(`["not","object"]`, ENCODING_FORM, "")
+	assert.NotNil(err)
+}
+
+func TestEncodeBodyMultipart(t *testing.T) {
+	assert := assert.New(t)
+
+	content := `{"fields":{"dealer":"d1","meta":{"n":2}},"files":[{"field":"file","filename":"a.txt","content_type":"text/plain","data":"aGVsbG8="}]}`
+	body, ct, err := encodeBody(content, ENCODING_MULTIPART, "application/json")
+	assert.Nil(err)
+
+	mediaType, params, err := mime.ParseMediaType(ct)
+	assert.Nil(err)
+	assert.Equal("multipart/form-data", mediaType)
+
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(1 << 20)
+	assert.Nil(err)
+	assert.Equal([]string{"d1"}, form.Value["dealer"])
+	assert.Equal([]string{"2"}, form.Value["meta[n]"])
+	if assert.Len(form.File["file"], 1) {
+		header := form.File["file"][0]
+		assert.Equal("a.txt", header.Filename)
+		assert.Equal("text/plain", header.Header.Get("Content-Type"))
+		f, err := header.Open()
+		assert.Nil(err)
+		raw, _ := ioutil.ReadAll(f)
+		assert.Equal("hello", string(raw))
+	}
+}
+
+func TestEncodeBodyXml(t *testing.T) {
+	assert := assert.New(t)
+
+	body, ct, err := encodeBody(`{"return_code":"SUCCESS","items":["a","b"],"detail":{"memo":"<x&y>"}}`, ENCODING_XML, "")
+	assert.Nil(err)
+	assert.Equal("application/xml; charset=utf-8", ct)
+	assert.Equal("<xml><detail><memo>&lt;x&amp;y&gt;</memo></detail><items>a</items><items>b</items><return_code>SUCCESS</return_code></xml>", string(body))
+
+	_, _, err = encodeBody(`{"bad name":"x"}`, ENCODING_XML, "")
+	assert.NotNil(err)
+}
+
+func TestEncodeBodyTextAndBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	body, ct, err := encodeBody("plain text", ENCODING_TEXT, "")
+	assert.Nil(err)
+	assert.Equal("text/plain; charset=utf-8", ct)
+	assert.Equal("plain text", string(body))
+
+	body, ct, err = encodeBody("AAEC/w==", ENCODING_BINARY, "")
+	assert.Nil(err)
+	assert.Equal("application/octet-stream", ct)
+	assert.Equal([]byte{0, 1, 2, 255}, body)
+
+	_, _, err = encodeBody("!!!", ENCODING_BINARY, "")
+	assert.NotNil(err)
+
+	_, _, err = encodeBody("x", "yaml", "")
+	assert.NotNil(err)
+}
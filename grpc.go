@@ -0,0 +1,183 @@
+package notification
+
+import (
+	receiver "./receiver"
+
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	CHANNEL_GRPC = "grpc" // gRPC, 通知地址为 grpc://host:port (明文) 或 grpcs://host:port (TLS), 调用 NotificationReceiver.Deliver
+
+	E_NOT_GRPC = "The url of grpc destination must be grpc://host:port or grpcs://host:port"
+)
+
+// gRPC 通道配置
+type GrpcConfig struct {
+	Timeout            time.Duration // 每次调用的超时时间, 0 表示 30 秒
+	CaFile             string        // grpcs 使用的 CA 证书, 为空时使用系统证书
+	InsecureSkipVerify bool          // grpcs 不校验证书, 只用于测试
+}
+
+type grpcChannel struct {
+	mu        sync.Mutex
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	conns     map[string]*grpc.ClientConn
+}
+
+var grpcs = &grpcChannel{timeout: 30 * time.Second, tlsConfig: &tls.Config{}, conns: map[string]*grpc.ClientConn{}}
+
+func init() {
+	channels[CHANNEL_GRPC] = grpcs
+}
+
+// 设置 gRPC 通道, 由启动程序根据配置设置, 已建立的连接会被关闭
+func SetGrpcConfig(config GrpcConfig) (err error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.InsecureSkipVerify}
+	if config.CaFile != "" {
+		var pem []byte
+		if pem, err = ioutil.ReadFile(config.CaFile); err != nil {
+			return
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return errors.New("no certificate in " + config.CaFile)
+		}
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	grpcs.mu.Lock()
+	defer grpcs.mu.Unlock()
+	for target, conn := range grpcs.conns {
+		conn.Close()
+		delete(grpcs.conns, target)
+	}
+	grpcs.timeout = config.Timeout
+	grpcs.tlsConfig = tlsConfig
+	return
+}
+
+func (c *grpcChannel) Check(destination Destination) (err error) {
+	_, err = grpcTarget(destination.Url)
+	return
+}
+
+// 发送一次, 按 gRPC 状态码判断是否重试, 见 receiver/notification.proto
+func (c *grpcChannel) Send(ctx context.Context, delivery Delivery) (outcome Outcome) {
+	fn := "grpcChannel.Send"
+
+	destination := delivery.Destination
+	conn, err := c.conn(destination.Url)
+	if err != nil {
+		outcome.Status = SEND_PERMANENT
+		outcome.Err = err
+		return
+	}
+
+	if destination.Headers != "" {
+		var headers map[string]string
+		if err = json.Unmarshal([]byte(destination.Headers), &headers); err != nil {
+			outcome.Status = SEND_PERMANENT
+			outcome.Err = err
+			return
+		}
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(headers))
+	}
+	c.mu.Lock()
+	timeout := c.timeout
+	c.mu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := receiver.NewNotificationReceiverClient(conn).Deliver(ctx, &receiver.DeliverRequest{
+		Topic:     delivery.Topic,
+		Partition: delivery.Partition,
+		Offset:    delivery.Offset,
+		Key:       delivery.Key,
+		Event:     delivery.Event,
+		Tenant:    delivery.Tenant,
+		Attempts:  delivery.Attempts,
+		Content:   delivery.Content,
+	})
+	code := status.Code(err)
+	outcome.StatusCode = int(code)
+	outcome.Status = grpcStatus(code)
+	if err != nil {
+		glog.Errorf("@%s, receiver.Deliver failed, err=%s, url=%s", fn, err, destination.Url)
+		outcome.Result = status.Convert(err).Message()
+		if outcome.Status != SEND_DELIVERED {
+			outcome.Err = err
+		}
+		return
+	}
+	outcome.Result = res.GetMessage()
+	return
+}
+
+// 同一地址共用连接
+func (c *grpcChannel) conn(url string) (conn *grpc.ClientConn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn, ok := c.conns[url]; ok {
+		return conn, nil
+	}
+	target, err := grpcTarget(url)
+	if err != nil {
+		return
+	}
+	creds := insecure.NewCredentials()
+	if strings.HasPrefix(url, "grpcs://") {
+		creds = credentials.NewTLS(c.tlsConfig)
+	}
+	if conn, err = grpc.NewClient(target, grpc.WithTransportCredentials(creds)); err != nil {
+		return
+	}
+	c.conns[url] = conn
+	return
+}
+
+func grpcTarget(url string) (target string, err error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return
+	}
+	if (u.Scheme != "grpc" && u.Scheme != "grpcs") || u.Host == "" {
+		err = errors.New(E_NOT_GRPC)
+		return
+	}
+	return u.Host, nil
+}
+
+// gRPC 状态码对应的发送结果
+func grpcStatus(code codes.Code) string {
+	switch code {
+	case codes.OK, codes.AlreadyExists:
+		return SEND_DELIVERED
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.Unauthenticated,
+		codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented, codes.DataLoss:
+		return SEND_PERMANENT
+	}
+	// Unavailable, DeadlineExceeded, ResourceExhausted, Aborted, Internal, Unknown, Canceled
+	return SEND_RETRY
+}
@@ -0,0 +1,72 @@
+package notification
+
+import (
+	receiver "./receiver"
+
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+type metadataRecorder struct {
+	receiver.Recorder
+	token string
+}
+
+func (r *metadataRecorder) Deliver(ctx context.Context, req *receiver.DeliverRequest) (*receiver.DeliverResponse, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("token")) > 0 {
+		r.token = md.Get("token")[0]
+	}
+	return r.Recorder.Deliver(ctx, req)
+}
+
+func TestGrpcChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	server := grpc.NewServer()
+	recorder := &metadataRecorder{Recorder: receiver.Recorder{Fail: []codes.Code{codes.Unavailable, codes.InvalidArgument}}}
+	receiver.RegisterNotificationReceiverServer(server, recorder)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	channel, ok := getChannel(CHANNEL_GRPC)
+	assert.True(ok)
+	assert.NotNil(channel.Check(Destination{Url: "http://a.com"}))
+	destination := Destination{Channel: CHANNEL_GRPC, Url: "grpc://" + listener.Addr().String(), Headers: `{"token":"abc"}`}
+	assert.Nil(channel.Check(destination))
+
+	delivery := Delivery{Content: `{"a":1}`, Destination: destination, Topic: "mytopic", Offset: 10, Event: "order.paid"}
+	outcome := channel.Send(context.Background(), delivery)
+	assert.Equal(SEND_RETRY, outcome.Status)
+	assert.Equal(int(codes.Unavailable), outcome.StatusCode)
+
+	outcome = channel.Send(context.Background(), delivery)
+	assert.Equal(SEND_PERMANENT, outcome.Status)
+
+	outcome = channel.Send(context.Background(), delivery)
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.Nil(outcome.Err)
+	assert.Equal("ok", outcome.Result)
+
+	received := recorder.Received()
+	assert.Len(received, 3)
+	assert.True(proto.Equal(&receiver.DeliverRequest{Topic: "mytopic", Offset: 10, Event: "order.paid", Content: `{"a":1}`}, received[2]))
+	assert.Equal("abc", recorder.token)
+}
+
+func TestGrpcStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(SEND_DELIVERED, grpcStatus(codes.AlreadyExists))
+	assert.Equal(SEND_RETRY, grpcStatus(codes.DeadlineExceeded))
+	assert.Equal(SEND_RETRY, grpcStatus(codes.ResourceExhausted))
+	assert.Equal(SEND_PERMANENT, grpcStatus(codes.Unimplemented))
+}
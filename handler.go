@@ -1,91 +1,379 @@
 package notification
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	neturl "net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
-	"github.com/go-redis/redis"
 	"github.com/golang/glog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 const (
 	E_CAPPED = "The attempts has been capped"
 )
 
+// 过期的通知是否放入死信列表, 由启动程序根据配置设置
+var ExpiredToDeadLetter bool
+
 // 执行消息发送 (http post), 注意该程序只对消息进行发送, 不改变消息本身
 // msg 表示 kafka 原始消息
-// dest 如果发送失败, 那么将 offset 写入(传递)到该 redis list (RPUSH)
-// retryData 重试所需的数据, 并且用于写入到 redis hash (HMSET)
-func Fire(_redis *redis.Client, msg *sarama.ConsumerMessage, dest string, retryData MessageRetry) (err error) {
+// store 保存重试数据, 见 RetryStore
+// dest 如果发送失败, 那么将重试数据写入(传递)到该重试列表
+// retryData 重试所需的数据, 并且用于写入到重试数据 (hash), 为空时表示首次发送, 发送到所有通知地址, 否则只发送到 retryData.Destination
+// ctx 取消时不再发送, 正在发送的请求中止, 通知放入重试列表
+func Fire(ctx context.Context, store RetryStore, msg *sarama.ConsumerMessage, dest string, retryData MessageRetry) (err error) {
 	fn := "Fire"
-	glog.Infof("@%s, kafka message=%+v", fn, msg)
+	fields := Fields{"fn": fn, "topic": msg.Topic, "partition": msg.Partition, "offset": msg.Offset, "key": string(msg.Key), "attempt": retryData.Attempts}
+	logf(LOG_DEBUG, "kafka message", fields.With(FIELD_CONTENT, string(msg.Value)))
+	ctx, span := startConsumeSpan(ctx, msg, retryData)
+	defer func() {
+		endSpan(span, err)
+	}()
 
 	// 10 json 解码
 	var message Message
+	_, decodeSpan := tracer().Start(ctx, SPAN_DECODE)
 	err = json.Unmarshal(msg.Value, &message)
+	endSpan(decodeSpan, err)
 	if err != nil {
-		glog.Errorf("@%s, message does not json format, msg.Value:%v\n", msg.Value)
+		logf(LOG_ERROR, "message does not json format", fields.With("err", err, FIELD_CONTENT, string(msg.Value)))
+		countOutcome(OUTCOME_INVALID)
+		reportStatus(msg, messageId(msg, MessageMeta{}), Destination{}, retryData, OUTCOME_INVALID, Outcome{})
 		return
 	}
-	glog.Infof("@%s, human readable message=%+v", fn, message)
+	routeByHeaders(&message.Meta, kafkaHeaders(msg))
+	fields["id"] = messageId(msg, message.Meta)
+	fields["event"] = message.Meta.Event
+	fields["tenant"] = message.Meta.Tenant
+	logf(LOG_INFO, "message received", fields.With(FIELD_CONTENT, message.Content))
+
+	// 20 每个通知地址独立发送和重试, 指定 event 时通知地址为匹配的订阅
+	if retryData != (MessageRetry{}) {
+		destination, found, e := findDestination(store, message.Meta, retryData)
+		if e != nil {
+			err = e
+			return
+		}
+		if !found {
+			logf(LOG_ERROR, "destination not found", fields.With("destination", retryData.Destination, "subscription", retryData.Subscription, "outcome", OUTCOME_INVALID))
+			countOutcome(OUTCOME_INVALID)
+			reportStatus(msg, messageId(msg, message.Meta), Destination{}, retryData, OUTCOME_INVALID, Outcome{})
+			// 订阅已删除时不再发送, 释放该 key 给下一条排队的消息
+			if isOrdered(msg) {
+				settleKey(store, msg, retryData, false)
+			}
+			return
+		}
+		return deliver(ctx, store, msg, message, destination, dest, retryData)
+	}
 
-	// 20 检查 URL 正确性
-	if err = checkUrl(message.Meta.Url); err != nil {
-		glog.Infof("@%s, 通知地址不正确, 不通知, message=%+v, err=%s", fn, message, err)
+	destinations, subscriptionIds, err := resolveDestinations(store, message.Meta)
+	if err != nil {
+		logf(LOG_ERROR, "resolveDestinations failed", fields.With("err", err))
 		return
 	}
+	if len(destinations) == 0 {
+		logf(LOG_WARNING, "no subscription", fields.With("outcome", OUTCOME_UNROUTED))
+		countOutcome(OUTCOME_UNROUTED)
+		reportStatus(msg, messageId(msg, message.Meta), Destination{}, MessageRetry{Offset: msg.Offset, Partition: msg.Partition}, OUTCOME_UNROUTED, Outcome{})
+		return
+	}
+	retryData = MessageRetry{Offset: msg.Offset, Partition: msg.Partition, Subscription: subscriptionIds[0]}
+	if len(destinations) == 1 {
+		return deliver(ctx, store, msg, message, destinations[0], NextRetryList(msg.Topic, 0), retryData)
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []string
+	)
+	for i, destination := range destinations {
+		wg.Add(1)
+		go func(i int, destination Destination) {
+			defer wg.Done()
+			data := retryData
+			data.Destination = int32(i)
+			data.Subscription = subscriptionIds[i]
+			if e := deliver(ctx, store, msg, message, destination, NextRetryList(msg.Topic, 0), data); e != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Sprintf("destination %d: %s", i, e))
+				mu.Unlock()
+			}
+		}(i, destination)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		err = errors.New(strings.Join(errs, "; "))
+	}
+	return
+}
 
-	// 30 HTTP 请求并预防一般性网络出错
-	var result string
-	sleepTime := time.Second * 1
-	for i := 1; i <= 3; i++ {
-		if result, err = post(message.Content, message.Meta.Url, message.Meta.Headers); err == nil {
-			break
+// 发送到一个通知地址, 失败时放入重试列表
+// retryData.NextTime 为 0 表示首次发送 (Attempts 为 0 的延迟, 排队的消息 NextTime 不为 0)
+// ctx 为处理消息的 span, 每次发送和放入重试列表为其子 span
+func deliver(ctx context.Context, store RetryStore, msg *sarama.ConsumerMessage, message Message, destination Destination, dest string, retryData MessageRetry) (err error) {
+	fn := "deliver"
+	first := retryData.Attempts == 0 && retryData.NextTime == 0
+	id := messageId(msg, message.Meta)
+	fields := deliveryFields(fn, msg, id, destination, retryData)
+
+	// 05 顺序发送: 同一 key 有未完成的消息时排队, 处理结束后占用或释放该 key
+	parked := false
+	if isOrdered(msg) {
+		if first {
+			if held, e := holdKey(store, msg, retryData); e != nil {
+				glog.Errorf("@%s, holdKey failed, send without order, err=%s, topic=%s, member=%s", fn, e, msg.Topic, retryData.Member())
+			} else if held {
+				glog.Infof("@%s, held by an earlier message with the same key, topic=%s, key=%s, member=%s", fn, msg.Topic, msg.Key, retryData.Member())
+				countOutcome(OUTCOME_HELD)
+				return
+			}
 		}
-		glog.Infof("@%s, retrying, current attempts is: %d sleepTime: %v", fn, i, sleepTime)
-		time.Sleep(sleepTime)
+		defer func() {
+			settleKey(store, msg, retryData, parked)
+		}()
 	}
 
-	// 40 检查请求返回是否如期望
-	needRetry := checkResult(result)
+	// 10 检查发送通道和通知地址正确性
+	channel, ok := getChannel(destination.Channel)
+	if !ok {
+		logf(LOG_WARNING, "unknown channel", fields.With("outcome", OUTCOME_INVALID))
+		countOutcome(OUTCOME_INVALID)
+		reportStatus(msg, id, destination, retryData, OUTCOME_INVALID, Outcome{})
+		err = errors.New(E_UNKNOWN_CHANNEL)
+		return
+	}
+	if err = channel.Check(destination); err != nil {
+		logf(LOG_WARNING, "invalid destination", fields.With("err", err, "outcome", OUTCOME_INVALID))
+		countOutcome(OUTCOME_INVALID)
+		reportStatus(msg, id, destination, retryData, OUTCOME_INVALID, Outcome{})
+		return
+	}
+	headers := kafkaHeaders(msg)
+	destination = mapHeaders(destination, headers)
 
-	if needRetry == false {
-		glog.Infof("@%s, post success, message=%v, response=%s", fn, message, result)
+	// 22 已过期的通知不再发送
+	expiresAt := message.Meta.expireTime(msg.Timestamp)
+	if isExpired(expiresAt) {
+		err = expire(store, msg, id, destination, retryData, expiresAt)
 		return
-	} else {
-		glog.Infof("@%s, post failed, message=%v, response=%s", fn, message, result)
 	}
 
-	// 50 放入重试列表
-	if retryData == (MessageRetry{}) {
-		retryData.Offset = msg.Offset
-		retryData.Partition = msg.Partition
-		retryData.Attempts = int32(0)
-		retryData.NextTime = int64(0)
-		dest = fmt.Sprintf(FORMAT_LIST, msg.Topic, 2, "4m")
+	// 25 未到发送时间的消息放入延迟队列, 由重试程序到期后发送
+	// 首次发送和排队后释放的消息 (Attempts 为 0) 都要检查, 延迟后到期的消息不会再次放入
+	if retryData.Attempts == 0 {
+		if deliverAt := message.Meta.deliverTime(msg.Timestamp); deliverAt > timeNow().Unix() {
+			retryData.NextTime = deliverAt
+			_, span := startScheduleSpan(ctx, SCHEDULE_DELAY, retryData, fmt.Sprintf(FORMAT_DELAY, msg.Topic))
+			err = gotoDelay(store, msg.Topic, retryData)
+			endSpan(span, err)
+			if err != nil {
+				glog.Errorf("@%s, gotoDelay failed, err=%s, topic=%s, retryData=%+v", fn, err, msg.Topic, retryData)
+				return
+			}
+			logf(LOG_INFO, "delayed", fields.With("deliver_at", deliverAt, "outcome", OUTCOME_DELAYED))
+			countOutcome(OUTCOME_DELAYED)
+			parked = true
+			return
+		}
+	}
+
+	// 27 合并发送: 首次发送的 json 通知交给 batcher, 由 batcher 发送并将失败的通知放入重试列表
+	if first && !isOrdered(msg) && (destination.Channel == "" || destination.Channel == CHANNEL_HTTP) && (destination.Encoding == "" || destination.Encoding == ENCODING_JSON) {
+		if rule, ok := batches.match(destination.Url); ok {
+			batches.add(store, msg, id, message.Content, destination, retryData, expiresAt, rule)
+			return
+		}
+	}
+
+	// 30 通过发送通道发送, 网络错误, 超时和 503 等可能很快恢复的失败按 RetryPolicy 立即重试, 见 backoff.go
+	// 熔断打开, ctx 取消 (如程序退出), 超过重试次数或时间预算时放入重试列表
+	outcome := Outcome{Status: SEND_RETRY}
+	host := urlHost(destination.Url)
+	timeouts := resolveTimeouts(message.Meta.Timeouts, destination.Url)
+	policy := getRetryPolicy()
+	started := time.Now()
+	for i := 1; ; i++ {
+		if ctx.Err() != nil {
+			outcome = Outcome{Status: SEND_RETRY, Err: ctx.Err()}
+			err = outcome.Err
+			break
+		}
+		if i > 1 && isExpired(expiresAt) {
+			err = expire(store, msg, id, destination, retryData, expiresAt)
+			return
+		}
+		// 超过限流时等待, 等待过久则放入延迟队列; 等待时 ctx 取消则放入重试列表
+		throttled, e := limiter.take(ctx, store, destination.Url)
+		if e != nil {
+			outcome = Outcome{Status: SEND_RETRY, Err: e}
+			err = e
+			break
+		}
+		if throttled > 0 {
+			err = throttle(ctx, store, msg, retryData, throttled)
+			parked = err == nil
+			return
+		}
+		if !breakers.allow(host) {
+			logf(LOG_WARNING, "circuit breaker is open, skip send", fields)
+			break
+		}
+		// 40 由发送通道检查返回是否如期望
+		sendCtx, sendSpan := startSendSpan(ctx, destination, retryData, i)
+		sendCtx, cancel := timeouts.context(sendCtx)
+		outcome = channel.Send(sendCtx, Delivery{
+			Content:     message.Content,
+			Destination: destination,
+			Topic:       msg.Topic,
+			Partition:   msg.Partition,
+			Offset:      msg.Offset,
+			Key:         string(msg.Key),
+			Event:       message.Meta.Event,
+			Tenant:      message.Meta.Tenant,
+			Attempts:    retryData.Attempts,
+			Headers:     headers,
+			Timeouts:    timeouts,
+		})
+		cancel()
+		endSendSpan(sendSpan, outcome)
+		err = outcome.Err
+		// allow 之后必须记录结果, 否则半开状态的试探名额不会释放
+		// 不再重试的结果 (如群机器人的 token 错误) 说明 host 可用, 只是通知本身有误, 不计为失败
+		breakers.record(host, outcome.Status == SEND_DELIVERED || outcome.Status == SEND_PERMANENT || isPermanent(outcome.Err))
+		if i >= policy.MaxTries || !policy.retryable(outcome) {
+			break
+		}
+		wait := policy.backoff(i)
+		if time.Since(started)+wait > policy.Budget {
+			logf(LOG_INFO, "retry budget exhausted", fields.With("try", i, "elapsed", time.Since(started).String(), "status_code", outcome.StatusCode, "err", outcome.Err))
+			break
+		}
+		logf(LOG_INFO, "retrying", fields.With("try", i, "sleep", wait.String(), "status_code", outcome.StatusCode, "err", outcome.Err))
+		if !sleepContext(ctx, wait) {
+			break
+		}
+	}
+
+	switch outcome.Status {
+	case SEND_DELIVERED:
+		logf(LOG_INFO, "send success", fields.With("outcome", OUTCOME_DELIVERED, "status_code", outcome.StatusCode, FIELD_RESPONSE, outcome.Result))
+		countOutcome(OUTCOME_DELIVERED)
+		reportStatus(msg, id, destination, retryData, OUTCOME_DELIVERED, outcome)
+		return
+	case SEND_PERMANENT:
+		logf(LOG_WARNING, "send rejected", fields.With("outcome", OUTCOME_REJECTED, "status_code", outcome.StatusCode, "err", outcome.Err, FIELD_RESPONSE, outcome.Result))
+		countOutcome(OUTCOME_REJECTED)
+		reportStatus(msg, id, destination, retryData, OUTCOME_REJECTED, outcome)
+		_, span := startScheduleSpan(ctx, SCHEDULE_DEAD, retryData, fmt.Sprintf(FORMAT_DEAD, msg.Topic))
+		err = gotoDead(store, msg.Topic, retryData, OUTCOME_REJECTED)
+		endSpan(span, err)
+		if err != nil {
+			glog.Errorf("@%s, gotoDead failed, err=%s, topic=%s, retryData=%+v", fn, err, msg.Topic, retryData)
+		}
+		return
+	default:
+		logf(LOG_INFO, "send failed", fields.With("status_code", outcome.StatusCode, "err", outcome.Err, FIELD_RESPONSE, outcome.Result))
 	}
-	if err = gotoRetry(_redis, msg.Topic, retryData, dest); err != nil {
+
+	// 50 放入重试列表, 达到该地址的最多重试次数时不再重试
+	if destination.MaxAttempts > 0 && retryData.Attempts >= destination.MaxAttempts {
+		dest = ""
+	}
+	_, span := startScheduleSpan(ctx, SCHEDULE_RETRY, retryData, dest)
+	err = gotoRetry(store, msg.Topic, retryData, dest)
+	if err != nil && fmt.Sprint(err) == E_CAPPED {
+		span.SetAttributes(attribute.Bool("notification.capped", true))
+		endSpan(span, nil)
+	} else {
+		endSpan(span, err)
+	}
+	if err != nil {
 		if fmt.Sprint(err) == E_CAPPED {
-			glog.Warningf("@%s, The attempts has been capped, message=%v, response=%s", fn, message, result)
+			logf(LOG_WARNING, "attempts capped", fields.With("outcome", OUTCOME_CAPPED, "status_code", outcome.StatusCode))
+			countOutcome(OUTCOME_CAPPED)
+			reportStatus(msg, id, destination, retryData, OUTCOME_CAPPED, outcome)
 			err = nil
 		} else {
 			glog.Errorf("@%s, gotoRetry failed, err=%s, topic=%s, retryData=%+v, dest=%s", fn, err, msg.Topic, retryData, dest)
 		}
 		return
 	}
+	countOutcome(OUTCOME_RETRY_SCHEDULED)
+	reportStatus(msg, id, destination, retryData, OUTCOME_RETRY_SCHEDULED, outcome)
+	parked = true
 
 	return
 }
 
-func gotoRetry(_redis *redis.Client, topic string, retryData MessageRetry, dest string) (err error) {
+// 超过限流, wait 之后由重试程序从延迟队列中取出发送, 保留已尝试次数
+func throttle(ctx context.Context, store RetryStore, msg *sarama.ConsumerMessage, retryData MessageRetry, wait time.Duration) (err error) {
+	fn := "throttle"
+
+	retryData.NextTime = timeNow().Add(wait).Unix() + 1
+	_, span := startScheduleSpan(ctx, SCHEDULE_THROTTLE, retryData, fmt.Sprintf(FORMAT_DELAY, msg.Topic))
+	err = gotoDelay(store, msg.Topic, retryData)
+	endSpan(span, err)
+	if err != nil {
+		glog.Errorf("@%s, gotoDelay failed, err=%s, topic=%s, retryData=%+v", fn, err, msg.Topic, retryData)
+		return
+	}
+	logf(LOG_INFO, "rate limited", Fields{"fn": fn, "topic": msg.Topic, "partition": msg.Partition, "offset": msg.Offset, "destination": retryData.Destination, "attempt": retryData.Attempts, "wait": wait.String(), "outcome": OUTCOME_THROTTLED})
+	countOutcome(OUTCOME_THROTTLED)
+	return
+}
+
+func isExpired(expiresAt int64) bool {
+	return expiresAt > 0 && timeNow().Unix() >= expiresAt
+}
+
+// 通知已过期, 不再发送, ExpiredToDeadLetter 时放入死信列表
+func expire(store RetryStore, msg *sarama.ConsumerMessage, id string, destination Destination, retryData MessageRetry, expiresAt int64) (err error) {
+	fn := "expire"
+	logf(LOG_WARNING, "notification expired", deliveryFields(fn, msg, id, destination, retryData).With("expires_at", expiresAt, "outcome", OUTCOME_EXPIRED))
+	countOutcome(OUTCOME_EXPIRED)
+	reportStatus(msg, id, destination, retryData, OUTCOME_EXPIRED, Outcome{})
+
+	if !ExpiredToDeadLetter {
+		return
+	}
+	if err = gotoDead(store, msg.Topic, retryData, OUTCOME_EXPIRED); err != nil {
+		glog.Errorf("@%s, gotoDead failed, err=%s, topic=%s, retryData=%+v", fn, err, msg.Topic, retryData)
+	}
+	return
+}
+
+// 放入死信列表, reason 记录在重试数据中
+func gotoDead(store RetryStore, topic string, retryData MessageRetry, reason string) (err error) {
+	fn := "gotoDead"
+
+	listKey := fmt.Sprintf(FORMAT_DEAD, topic)
+	fields := retryData.Fields()
+	fields["reason"] = reason
+
+	if err = saveRetryData(store, retryData.HashKey(topic), fields, timeNow().AddDate(0, 0, 7)); err != nil {
+		return
+	}
+	if _, err = store.Push(listKey, retryData.Member()); err != nil {
+		glog.Errorf("@%s, store.Push failed, err=%s, key=%s, member=%s", fn, err, listKey, retryData.Member())
+		return
+	}
+	return
+}
+
+func gotoRetry(store RetryStore, topic string, retryData MessageRetry, dest string) (err error) {
 	fn := "gotoRetry"
 
 	// 10 如果 dest 为空表示最后一次通知完成则不再继续通知
@@ -99,20 +387,47 @@ func gotoRetry(_redis *redis.Client, topic string, retryData MessageRetry, dest
 	retryData.Attempts += 1
 	retryData.NextTime, intervalStr = getNextTime(retryData.Attempts)
 
-	// 30 追加到 redis list (RPUSH)
+	// 30 追加到重试列表
+	// 先写重试数据再追加到列表, 避免重试程序取到列表成员时重试数据还不存在
 	listKey := fmt.Sprintf(FORMAT_LIST, topic, retryData.Attempts+1, intervalStr)
-	hashKey := fmt.Sprintf(FORMAT_HASH, topic, retryData.Offset)
 
-	if _, err = _redis.RPush(listKey, retryData.Offset).Result(); err != nil {
-		glog.Errorf("@%s, _redis.RPush failed, err=%s, key=%s, offset=%d", fn, err, listKey, retryData.Offset)
+	if err = saveRetryData(store, retryData.HashKey(topic), retryData.Fields(), timeNow().AddDate(0, 0, 7)); err != nil {
 		return
 	}
-	if _, err = _redis.HMSet(hashKey, retryData.Fields()).Result(); err != nil {
-		glog.Errorf("@%s, _redis.HMSet failed, err=%s, key=%s, fields=%+v", fn, err, hashKey, retryData.Fields())
+	if _, err = store.Push(listKey, retryData.Member()); err != nil {
+		glog.Errorf("@%s, store.Push failed, err=%s, key=%s, member=%s", fn, err, listKey, retryData.Member())
 		return
 	}
-	if _, err = _redis.ExpireAt(hashKey, time.Now().AddDate(0, 0, 7)).Result(); err != nil {
-		glog.Errorf("@%s, _redis.ExpireAt failed, err=%s, key=%s, time=%s", fn, err, hashKey, time.Now().AddDate(0, 0, 7))
+	return
+}
+
+// 放入延迟队列, 到达 retryData.NextTime 后由重试程序发送
+func gotoDelay(store RetryStore, topic string, retryData MessageRetry) (err error) {
+	fn := "gotoDelay"
+
+	zsetKey := fmt.Sprintf(FORMAT_DELAY, topic)
+	expireAt := time.Unix(retryData.NextTime, 0).AddDate(0, 0, 7)
+
+	if err = saveRetryData(store, retryData.HashKey(topic), retryData.Fields(), expireAt); err != nil {
+		return
+	}
+	if err = store.Schedule(zsetKey, retryData.Member(), retryData.NextTime); err != nil {
+		glog.Errorf("@%s, store.Schedule failed, err=%s, key=%s, member=%s", fn, err, zsetKey, retryData.Member())
+		return
+	}
+	return
+}
+
+// 保存重试数据 (hash), expireAt 之后删除
+func saveRetryData(store RetryStore, hashKey string, fields map[string]interface{}, expireAt time.Time) (err error) {
+	fn := "saveRetryData"
+
+	if err = store.SetFields(hashKey, fields); err != nil {
+		glog.Errorf("@%s, store.SetFields failed, err=%s, key=%s, fields=%+v", fn, err, hashKey, fields)
+		return
+	}
+	if err = store.ExpireAt(hashKey, expireAt); err != nil {
+		glog.Errorf("@%s, store.ExpireAt failed, err=%s, key=%s, time=%s", fn, err, hashKey, expireAt)
 		return
 	}
 	return
@@ -121,7 +436,7 @@ func gotoRetry(_redis *redis.Client, topic string, retryData MessageRetry, dest
 // 下一次尝试时间
 // @link https://github.com/YunzhanghuOpen/notification/issues/4
 func getNextTime(attempted int32) (nextTime int64, intervalStr string) {
-	now := time.Now()
+	now := timeNow()
 	switch attempted {
 	case 1:
 		nextTime = now.Add(time.Minute * 4).Unix()
@@ -151,85 +466,110 @@ func getNextTime(attempted int32) (nextTime int64, intervalStr string) {
 	return
 }
 
+// 发送日志的公共字段, 不包含通知内容
+func deliveryFields(fn string, msg *sarama.ConsumerMessage, id string, destination Destination, retryData MessageRetry) Fields {
+	channel := destination.Channel
+	if channel == "" {
+		channel = CHANNEL_HTTP
+	}
+	return Fields{
+		"fn":           fn,
+		"id":           id,
+		"topic":        msg.Topic,
+		"partition":    msg.Partition,
+		"offset":       msg.Offset,
+		"destination":  retryData.Destination,
+		"subscription": retryData.Subscription,
+		"channel":      channel,
+		"url_host":     urlHost(destination.Url),
+		"attempt":      retryData.Attempts,
+	}
+}
+
 func checkUrl(url string) (err error) {
 	_, err = neturl.ParseRequestURI(url)
 	return
 }
 
-func post(jsonData string, url string, header string) (result string, err error) {
+// secret 不为空时对请求体签名, 见 sign
+// timeouts 中没有指定的超时时间使用按 host 配置的或默认的, ctx 取消时中止请求
+func post(ctx context.Context, jsonData string, url string, header string, encoding string, secret string, timeouts Timeouts) (result string, statusCode int, err error) {
 	fn := "post"
-	glog.Infof("@%s, url=%s, jsonData=%s, header=%v", fn, url, jsonData, header)
+	fields := Fields{"fn": fn, "url_host": urlHost(url), "encoding": encoding}
+	logf(LOG_DEBUG, "post request", fields.With("url", url, FIELD_HEADERS, header, FIELD_BODY, jsonData))
 
 	var headersMap map[string]string
 	if header != "" {
 		if err := json.Unmarshal([]byte(header), &headersMap); err != nil {
-			glog.Errorf("@%s, json.Unmarshal header failed, header=%s", fn, header)
-			return "", err
+			logf(LOG_ERROR, "json.Unmarshal header failed", fields.With("err", err, FIELD_HEADERS, header))
+			return "", 0, permanent(err)
 		}
 	}
 
-	var (
-		req     *http.Request
-		payload *strings.Reader
-	)
-
-	// 如果是自定义的 Content-Type: application/x-www-form-urlencoded 类型
-	type1, ok1 := headersMap["Content-Type"]
-	type2, ok2 := headersMap["content-type"]
-	if (ok1 || ok2) && (type1 == "application/x-www-form-urlencoded" || type2 == "application/x-www-form-urlencoded") {
-		glog.Infof("@%s, custom content-type:%s | %s", fn, type1, type2)
-
-		var dataMap map[string]string
-		if err := json.Unmarshal([]byte(jsonData), &dataMap); err != nil {
-			glog.Errorf("@%s, json.Unmarshal failed, jsonData:%s", fn, jsonData)
-			return "", err
-		}
-		glog.Infof("@%s, unmarshal dataMap=%+v", fn, dataMap)
-
-		v := neturl.Values{}
-		for key, val := range dataMap {
-			v.Add(key, val)
+	// 按 encoding 编码请求体, 兼容旧的自定义 Content-Type: application/x-www-form-urlencoded
+	var contentType string
+	for k, v := range headersMap {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			contentType = v
 		}
-		payload = strings.NewReader(v.Encode())
-		req, _ = http.NewRequest("POST", url, payload)
-	} else {
-		// 默认 Content-Type: application/json
-		payload = strings.NewReader(jsonData)
-		req, _ = http.NewRequest("POST", url, payload)
-		req.Header.Add("content-type", "application/json")
+	}
+	body, contentType, err := encodeBody(jsonData, encoding, contentType)
+	if err != nil {
+		logf(LOG_ERROR, "encodeBody failed", fields.With("err", err, FIELD_BODY, jsonData))
+		return "", 0, permanent(err)
 	}
 
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		logf(LOG_ERROR, "http.NewRequest failed", fields.With("err", err))
+		return "", 0, permanent(err)
+	}
+	req.Header.Set("Content-Type", contentType)
 	for k, v := range headersMap {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			continue
+		}
 		req.Header.Add(k, v)
 	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set(HEADER_TIMESTAMP, strconv.FormatInt(timestamp, 10))
+		req.Header.Set(HEADER_SIGNATURE, sign(secret, timestamp, body))
+	}
 
-	var tr *http.Transport
+	timeouts = resolveTimeouts(timeouts, url)
+	tr := &http.Transport{
+		DialContext:           timeouts.dialer().DialContext,
+		TLSHandshakeTimeout:   millis(timeouts.TlsHandshake),
+		ResponseHeaderTimeout: millis(timeouts.ResponseHeader),
+	}
 	if strings.Contains(url, "jiesuan.local") {
-		tr = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	} else {
-		tr = &http.Transport{}
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 	client := http.Client{
 		Transport: tr,
-		Timeout:   30 * time.Second,
+		Timeout:   millis(timeouts.Total),
 	}
 	res, err := client.Do(req)
 	if err != nil {
-		glog.Errorf("@%s, http.DefaultClient.Do(req), err=%s, req=%+v", fn, err, req)
-		return "", err
+		logf(LOG_ERROR, "http.Client.Do failed", fields.With("err", err, FIELD_HEADERS, req.Header))
+		return "", 0, err
 	}
 
+	// 返回内容最多读取 MaxBytes, 其余丢弃, 见 response.go
 	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	result, truncated, err := readResponse(res)
 	if err != nil {
-		glog.Errorf("@%s, ioutil.ReadAll(res.Body), err=%s, res.Body=%+v", fn, err, res.Body)
-		return "", err
+		logf(LOG_ERROR, "readResponse failed", fields.With("err", err, "status_code", res.StatusCode))
+		return "", res.StatusCode, err
 	}
+	if truncated {
+		logf(LOG_WARNING, "response too large, truncated", fields.With("status_code", res.StatusCode, "max_bytes", getResponseConfig().MaxBytes))
+	}
+	logf(LOG_INFO, "post response", fields.With("status_code", res.StatusCode, FIELD_HEADERS, res.Header, FIELD_RESPONSE, auditCopy(result)))
 
-	result = string(body)
-	glog.Infof("@%s, post: res = %v body = %v", fn, res, result)
-
-	return result, nil
+	return result, res.StatusCode, nil
 }
 
 func checkResult(ret string) (needRetry bool) {
@@ -250,7 +590,7 @@ func checkResult(ret string) (needRetry bool) {
 	}
 	var result2 Result2
 	if err := json.Unmarshal([]byte(ret), &result2); err != nil {
-		glog.Warningf("@%s, json.Unmarshal failed, err=%s, data=%s", fn, err, ret)
+		logf(LOG_WARNING, "json.Unmarshal result failed", Fields{"fn": fn, "err": err, FIELD_RESPONSE: ret})
 	} else {
 		if result2.Code == "0000" {
 			glog.Infof("@%s, check result, success", fn)
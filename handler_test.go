@@ -1,8 +1,15 @@
 package notification
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -10,6 +17,21 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// 需要 kafka 的测试, 地址为 KAFKA_PEERS (默认 localhost:9092), 连接不上时跳过
+func requireKafka(t *testing.T) []string {
+	brokers := os.Getenv("KAFKA_PEERS")
+	if brokers == "" {
+		brokers = "localhost:9092"
+	}
+	brokerList := strings.Split(brokers, ",")
+	conn, err := net.DialTimeout("tcp", brokerList[0], time.Second)
+	if err != nil {
+		t.Skipf("kafka is not available, err=%s", err)
+	}
+	conn.Close()
+	return brokerList
+}
+
 func TestPushMessage(t *testing.T) {
 	meta := &MessageMeta{
 		Url:         "http://localhost:8000/printall",
@@ -28,7 +50,7 @@ func TestPushMessage(t *testing.T) {
 	config.Producer.Compression = sarama.CompressionSnappy   // Compress messages
 	config.Producer.Flush.Frequency = 500 * time.Millisecond // Flush batches every 500ms
 
-	brokerList := strings.Split("localhost:9092", ",")
+	brokerList := requireKafka(t)
 	accessLogProducer, err := sarama.NewAsyncProducer(brokerList, config)
 	if err != nil {
 		fmt.Printf("sarama.NewAsyncProducer failed, err=%s", err)
@@ -60,7 +82,7 @@ func TestPushWithdrawSucc(t *testing.T) {
 	config.Producer.Compression = sarama.CompressionSnappy   // Compress messages
 	config.Producer.Flush.Frequency = 500 * time.Millisecond // Flush batches every 500ms
 
-	brokerList := strings.Split("localhost:9092", ",")
+	brokerList := requireKafka(t)
 	accessLogProducer, err := sarama.NewAsyncProducer(brokerList, config)
 	if err != nil {
 		fmt.Printf("sarama.NewAsyncProducer failed, err=%s", err)
@@ -139,7 +161,7 @@ func TestDebugMissing(t *testing.T) {
 	config.Producer.Compression = sarama.CompressionSnappy   // Compress messages
 	config.Producer.Flush.Frequency = 500 * time.Millisecond // Flush batches every 500ms
 
-	brokerList := strings.Split("localhost:9092", ",")
+	brokerList := requireKafka(t)
 	accessLogProducer, err := sarama.NewAsyncProducer(brokerList, config)
 	if err != nil {
 		fmt.Printf("sarama.NewAsyncProducer failed, err=%s", err)
@@ -164,3 +186,160 @@ func TestDebugMissing(t *testing.T) {
 
 	t.Log("Successfully pushed a message.")
 }
+
+func TestMetaDeliverAndExpireTime(t *testing.T) {
+	assert := assert.New(t)
+
+	produced := time.Unix(1500000000, 0)
+
+	meta := MessageMeta{}
+	assert.Equal(int64(0), meta.deliverTime(produced))
+	assert.Equal(int64(0), meta.expireTime(produced))
+
+	meta = MessageMeta{Delay: 60, Ttl: 3600}
+	assert.Equal(int64(1500000060), meta.deliverTime(produced))
+	assert.Equal(int64(1500003600), meta.expireTime(produced))
+	assert.Equal(int64(0), meta.expireTime(time.Time{}))
+
+	meta = MessageMeta{DeliverAt: 1600000000, Delay: 60, ExpiresAt: 1700000000, Ttl: 3600}
+	assert.Equal(int64(1600000000), meta.deliverTime(produced))
+	assert.Equal(int64(1700000000), meta.expireTime(produced))
+
+	assert.False(isExpired(0))
+	assert.True(isExpired(time.Now().Unix() - 1))
+	assert.False(isExpired(time.Now().Unix() + 60))
+}
+
+func TestParseMember(t *testing.T) {
+	assert := assert.New(t)
+
+	retryData := MessageRetry{Offset: 1024, Partition: 3, Destination: 1}
+	assert.Equal("3:1024:1", retryData.Member())
+
+	parsed, hashKey, err := ParseMember("mytopic", retryData.Member())
+	assert.Nil(err)
+	assert.Equal(retryData, parsed)
+	assert.Equal("mytopic-hash-3-1024-1", hashKey)
+
+	// 旧格式
+	parsed, hashKey, err = ParseMember("mytopic", "1024")
+	assert.Nil(err)
+	assert.Equal(MessageRetry{Offset: 1024}, parsed)
+	assert.Equal("mytopic-hash-offset-1024", hashKey)
+
+	_, _, err = ParseMember("mytopic", "3:1024")
+	assert.NotNil(err)
+}
+
+func TestCheckers(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(getChecker("")(200, "success"))
+	assert.True(getChecker(CHECKER_DEFAULT)(200, "ok"))
+	assert.False(getChecker(CHECKER_STATUS)(204, ""))
+	assert.True(getChecker(CHECKER_STATUS)(503, "success"))
+	assert.False(getChecker("json:errcode=0")(200, `{"errcode":0,"errmsg":"ok"}`))
+	assert.True(getChecker("json:errcode=0")(200, `{"errcode":310000}`))
+	assert.True(getChecker("json:errcode=0")(200, `ok`))
+	assert.True(getChecker("unknown")(200, "ok"))
+}
+
+func TestDestinations(t *testing.T) {
+	assert := assert.New(t)
+
+	meta := MessageMeta{Url: "http://a.com", Headers: `{"k":"v"}`, Encoding: ENCODING_FORM}
+	assert.Equal([]Destination{{Url: "http://a.com", Headers: `{"k":"v"}`, Encoding: ENCODING_FORM}}, meta.destinations())
+
+	meta.Destinations = []Destination{{Url: "http://b.com"}, {Url: "http://c.com", Checker: CHECKER_STATUS, MaxAttempts: 2}}
+	assert.Equal(meta.Destinations, meta.destinations())
+}
+
+// 测试用的 kafka 消息, 消息时间为当前 (假) 时间
+func testMessage(offset int64, content string, meta MessageMeta) *sarama.ConsumerMessage {
+	value, _ := json.Marshal(Message{Content: content, Meta: meta})
+	return &sarama.ConsumerMessage{Topic: "mytopic", Partition: 1, Offset: offset, Value: value, Timestamp: timeNow()}
+}
+
+// 返回 statuses 中的状态码, 用完后返回最后一个, 状态码为 200 时返回 success
+func testReceiver(statuses ...int) (server *httptest.Server, requests *int32) {
+	requests = new(int32)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(requests, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[i])
+		if statuses[i] == http.StatusOK {
+			w.Write([]byte("success"))
+		}
+	}))
+	return
+}
+
+func TestFire(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	server, requests := testReceiver(http.StatusOK, http.StatusInternalServerError)
+	defer server.Close()
+
+	// 发送成功
+	err := Fire(context.Background(), store, testMessage(10, `{"id":1}`, MessageMeta{Url: server.URL}), "", MessageRetry{})
+	assert.Nil(err)
+	assert.Equal(int32(1), atomic.LoadInt32(requests))
+	members, _ := store.Range(RetryLists("mytopic")[0])
+	assert.Len(members, 0)
+
+	// 发送失败, 放入第一个重试列表, 4 分钟后重试
+	err = Fire(context.Background(), store, testMessage(11, `{"id":2}`, MessageMeta{Url: server.URL}), "", MessageRetry{})
+	assert.Nil(err)
+	assert.Equal(int32(2), atomic.LoadInt32(requests))
+	members, _ = store.Range(RetryLists("mytopic")[0])
+	assert.Equal([]string{"1:11:0"}, members)
+	fields, _ := store.GetFields("mytopic-hash-1-11-0")
+	assert.Equal("1", fields["attempts"])
+	assert.Equal(fmt.Sprint(clock.Now().Add(4*time.Minute).Unix()), fields["next_time"])
+
+	// 无法解析的消息不发送
+	err = Fire(context.Background(), store, &sarama.ConsumerMessage{Topic: "mytopic", Value: []byte("oops")}, "", MessageRetry{})
+	assert.NotNil(err)
+	assert.Equal(int32(2), atomic.LoadInt32(requests))
+
+	// 未到发送时间的消息放入延迟队列
+	err = Fire(context.Background(), store, testMessage(12, `{"id":3}`, MessageMeta{Url: server.URL, Delay: 60}), "", MessageRetry{})
+	assert.Nil(err)
+	assert.Equal(int32(2), atomic.LoadInt32(requests))
+	scheduled, _ := store.Scheduled("mytopic-zset-delayed")
+	assert.Equal([]ScheduledMember{{"1:12:0", clock.Now().Unix() + 60}}, scheduled)
+}
+
+func TestGotoRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	lists := RetryLists("mytopic")
+
+	retryData := MessageRetry{Offset: 10, Partition: 1, Destination: 2, Attempts: 1, NextTime: 1}
+	assert.Nil(gotoRetry(store, "mytopic", retryData, lists[1]))
+	members, _ := store.Range(lists[1])
+	assert.Equal([]string{"1:10:2"}, members)
+	attempts, nextTime, partition, subscription, err := loadRetryData(store, "mytopic-hash-1-10-2")
+	assert.Nil(err)
+	assert.Equal(int32(2), attempts)
+	assert.Equal(clock.Now().Add(10*time.Minute).Unix(), nextTime)
+	assert.Equal(int32(1), partition)
+	assert.Equal("", subscription)
+
+	// 重试数据保留 7 天
+	clock.Advance(7 * 24 * time.Hour)
+	_, _, _, _, err = loadRetryData(store, "mytopic-hash-1-10-2")
+	assert.NotNil(err)
+
+	// 达到重试上限
+	err = gotoRetry(store, "mytopic", retryData, "")
+	assert.Equal(E_CAPPED, fmt.Sprint(err))
+}
@@ -0,0 +1,34 @@
+package harness
+
+import (
+	"sync"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// 假时钟, 替换 notification 的当前时间, 推进时同步 miniredis 的时间, 使 key 按假时钟过期
+type Clock struct {
+	mu    sync.Mutex
+	now   time.Time
+	redis *miniredis.Miniredis
+}
+
+func newClock(now time.Time, redis *miniredis.Miniredis) *Clock {
+	redis.SetTime(now)
+	return &Clock{now: now, redis: redis}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.redis.SetTime(c.now)
+	c.redis.FastForward(d)
+}
@@ -0,0 +1,174 @@
+// 端到端测试工具: 用 sarama mocks 代替 kafka, miniredis 代替 redis, httptest 接收通知, 假时钟控制重试时间
+// 按实时处理程序 (listener) 和重试程序 (listener-retry) 的方式发送, 几秒内跑完以小时计的重试过程
+package harness
+
+import (
+	notification ".."
+
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+const (
+	START_TIME    = 1500000000      // 假时钟的开始时间 (Unix 时间戳)
+	POLL_INTERVAL = 1 * time.Minute // 重试程序每轮取出的间隔, 与 listener-retry 相同
+	PARTITION     = 0               // 消息都发布到该 partition
+)
+
+type Harness struct {
+	Topic    string
+	Clock    *Clock
+	Redis    *miniredis.Miniredis
+	Store    notification.RetryStore
+	Receiver *Receiver
+
+	t         testing.TB
+	client    *redis.Client
+	consumer  *mocks.Consumer
+	expect    *mocks.PartitionConsumer
+	partition sarama.PartitionConsumer
+	source    *notification.MemorySource
+	retrier   *notification.Retrier
+}
+
+// 启动 miniredis, 接收方和 kafka mocks, 并将 notification 的时钟替换为假时钟, 测试结束时调用 Close
+func New(t testing.TB, topic string) *Harness {
+	t.Helper()
+
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run failed, err=%s", err)
+	}
+	clock := newClock(time.Unix(START_TIME, 0), mr)
+	notification.SetClock(clock.Now)
+
+	h := &Harness{
+		Topic:    topic,
+		Clock:    clock,
+		Redis:    mr,
+		Receiver: NewReceiver(clock),
+		t:        t,
+		client:   redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		consumer: mocks.NewConsumer(t, nil),
+		source:   notification.NewMemorySource(),
+	}
+	h.Store = notification.NewRedisStore(h.client)
+	h.retrier = notification.NewRetrier(topic, h.Store, h.source)
+
+	h.expect = h.consumer.ExpectConsumePartition(topic, PARTITION, sarama.OffsetNewest)
+	if h.partition, err = h.consumer.ConsumePartition(topic, PARTITION, sarama.OffsetNewest); err != nil {
+		t.Fatalf("consumer.ConsumePartition failed, err=%s", err)
+	}
+	return h
+}
+
+func (h *Harness) Close() {
+	h.retrier.Wait()
+	notification.FlushBatches()
+	h.partition.Close()
+	h.consumer.Close()
+	h.Receiver.Close()
+	h.client.Close()
+	h.Redis.Close()
+	notification.SetClock(nil)
+}
+
+// 发布一条消息, 并像实时处理程序一样读取后发送, 返回读取到的 kafka 消息
+func (h *Harness) Publish(content string, meta notification.MessageMeta) *sarama.ConsumerMessage {
+	h.t.Helper()
+
+	value, err := json.Marshal(notification.Message{Content: content, Meta: meta})
+	if err != nil {
+		h.t.Fatalf("json.Marshal failed, err=%s", err)
+	}
+	return h.PublishMessage(&sarama.ConsumerMessage{Value: value})
+}
+
+// 发布 kafka 消息 (如带 key 或 headers 的消息), topic, partition 和 offset 由 mocks 设置, 消息时间为当前时间
+func (h *Harness) PublishMessage(msg *sarama.ConsumerMessage) *sarama.ConsumerMessage {
+	h.t.Helper()
+
+	msg.Timestamp = h.Clock.Now()
+	h.expect.YieldMessage(msg)
+	msg = <-h.partition.Messages()
+	h.source.Add(msg)
+	if err := notification.Fire(context.Background(), h.Store, msg, "", notification.MessageRetry{}); err != nil {
+		h.t.Logf("notification.Fire failed, err=%s, offset=%d", err, msg.Offset)
+	}
+	return msg
+}
+
+// 重试程序取出一轮到期的消息, 等待发送完成
+func (h *Harness) Poll() {
+	h.retrier.Poll(context.Background(), nil)
+	h.retrier.Wait()
+}
+
+// 按 POLL_INTERVAL 推进时钟 d, 每次推进后像重试程序一样取出到期的消息
+func (h *Harness) Advance(d time.Duration) {
+	for d > 0 {
+		step := POLL_INTERVAL
+		if d < step {
+			step = d
+		}
+		h.Clock.Advance(step)
+		h.Poll()
+		d -= step
+	}
+}
+
+// 从开始到当前的时间
+func (h *Harness) Elapsed() time.Duration {
+	return h.Clock.Now().Sub(time.Unix(START_TIME, 0))
+}
+
+// 接收方收到请求的时间, 相对于开始时间
+func (h *Harness) Timeline() []time.Duration {
+	return h.Receiver.Timeline(time.Unix(START_TIME, 0))
+}
+
+// 各级重试列表中的消息
+func (h *Harness) Retries() []notification.PendingEntry {
+	h.t.Helper()
+
+	entries, err := notification.ListRetries(h.Store, h.Topic)
+	if err != nil {
+		h.t.Fatalf("notification.ListRetries failed, err=%s", err)
+	}
+	return entries
+}
+
+// 延迟队列中的消息
+func (h *Harness) Delayed() []notification.PendingEntry {
+	h.t.Helper()
+
+	entries, err := notification.ListDelayed(h.Store, h.Topic)
+	if err != nil {
+		h.t.Fatalf("notification.ListDelayed failed, err=%s", err)
+	}
+	return entries
+}
+
+// 死信列表中的消息
+func (h *Harness) Dead() []notification.PendingEntry {
+	h.t.Helper()
+
+	entries, err := notification.ListDead(h.Store, h.Topic)
+	if err != nil {
+		h.t.Fatalf("notification.ListDead failed, err=%s", err)
+	}
+	return entries
+}
+
+// 消息在重试列表和延迟队列中的成员, 见 notification.MessageRetry.Member
+func Member(msg *sarama.ConsumerMessage, destination int32) string {
+	return fmt.Sprintf(notification.FORMAT_MEMBER, msg.Partition, msg.Offset, destination)
+}
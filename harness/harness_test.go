@@ -0,0 +1,133 @@
+package harness
+
+import (
+	notification ".."
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 各次发送的时间: 首次发送, 之后按 4m, 10m, 10m, 1h, 2h, 6h, 15h 的间隔重试
+var fullTimeline = []time.Duration{
+	0,
+	4 * time.Minute,
+	14 * time.Minute,
+	24 * time.Minute,
+	84 * time.Minute,
+	204 * time.Minute,
+	564 * time.Minute,
+	1464 * time.Minute,
+}
+
+func TestEventuallyDelivered(t *testing.T) {
+	assert := assert.New(t)
+
+	h := New(t, "mytopic")
+	defer h.Close()
+	h.Receiver.Enqueue(FAILURE, FAILURE, FAILURE)
+
+	msg := h.Publish(`{"id":1}`, notification.MessageMeta{Url: h.Receiver.URL + "/notify"})
+	assert.Equal([]string{Member(msg, 0)}, members(h.Retries()))
+
+	h.Advance(30 * time.Minute)
+	assert.Equal(fullTimeline[:4], h.Timeline())
+	assert.Len(h.Retries(), 0)
+	assert.Len(h.Dead(), 0)
+
+	// 每次发送的内容相同
+	for _, req := range h.Receiver.Requests() {
+		assert.Equal("/notify", req.Path)
+		assert.Equal(`{"id":1}`, req.Body)
+	}
+
+	// 成功后不再发送
+	h.Advance(24 * time.Hour)
+	assert.Len(h.Receiver.Requests(), 4)
+}
+
+func TestCapped(t *testing.T) {
+	assert := assert.New(t)
+
+	h := New(t, "mytopic")
+	defer h.Close()
+	h.Receiver.SetDefault(FAILURE)
+
+	msg := h.Publish(`{"id":1}`, notification.MessageMeta{Url: h.Receiver.URL})
+	member := Member(msg, 0)
+
+	// 依次进入各级重试列表, 熔断按假时钟恢复, 不影响重试时间
+	for i, list := range notification.RetryLists("mytopic") {
+		retries := h.Retries()
+		if assert.Len(retries, 1) {
+			assert.Equal(list, retries[0].List)
+			assert.Equal(member, retries[0].Member)
+			assert.Equal(int32(i+1), retries[0].Attempts)
+			assert.Equal(fullTimeline[i+1], time.Unix(retries[0].NextTime, 0).Sub(time.Unix(START_TIME, 0)))
+		}
+		h.Advance(fullTimeline[i+1] - h.Elapsed())
+	}
+
+	// 达到重试上限, 整个过程约一天
+	assert.Equal(fullTimeline, h.Timeline())
+	assert.Len(h.Retries(), 0)
+	h.Advance(24 * time.Hour)
+	assert.Len(h.Receiver.Requests(), len(fullTimeline))
+}
+
+func TestMaxAttemptsAndDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	h := New(t, "mytopic")
+	defer h.Close()
+	h.Receiver.SetDefault(FAILURE)
+
+	// 延迟 90 秒后首次发送, 最多重试 2 次
+	msg := h.Publish(`{"id":1}`, notification.MessageMeta{
+		Destinations: []notification.Destination{{Url: h.Receiver.URL, MaxAttempts: 2}},
+		Delay:        90,
+	})
+	delayed := h.Delayed()
+	if assert.Len(delayed, 1) {
+		assert.Equal(Member(msg, 0), delayed[0].Member)
+		assert.Equal(int64(START_TIME+90), delayed[0].NextTime)
+	}
+	assert.Len(h.Receiver.Requests(), 0)
+
+	h.Advance(24 * time.Hour)
+	assert.Equal([]time.Duration{2 * time.Minute, 6 * time.Minute, 16 * time.Minute}, h.Timeline())
+	assert.Len(h.Delayed(), 0)
+	assert.Len(h.Retries(), 0)
+}
+
+func TestExpiredToDeadLetter(t *testing.T) {
+	assert := assert.New(t)
+
+	notification.ExpiredToDeadLetter = true
+	defer func() {
+		notification.ExpiredToDeadLetter = false
+	}()
+	h := New(t, "mytopic")
+	defer h.Close()
+	h.Receiver.SetDefault(FAILURE)
+
+	// 有效期 10 分钟, 第二次重试时已过期
+	msg := h.Publish(`{"id":1}`, notification.MessageMeta{Url: h.Receiver.URL, Ttl: 600})
+	h.Advance(time.Hour)
+	assert.Equal(fullTimeline[:2], h.Timeline())
+	assert.Len(h.Retries(), 0)
+	dead := h.Dead()
+	if assert.Len(dead, 1) {
+		assert.Equal(Member(msg, 0), dead[0].Member)
+		assert.Equal(notification.OUTCOME_EXPIRED, dead[0].Reason)
+	}
+}
+
+func members(entries []notification.PendingEntry) []string {
+	members := make([]string, len(entries))
+	for i, entry := range entries {
+		members[i] = entry.Member
+	}
+	return members
+}
@@ -0,0 +1,91 @@
+package harness
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// 接收方收到的请求, At 为假时钟的时间
+type Request struct {
+	At     time.Time
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// 接收方的返回
+type Response struct {
+	Status int
+	Body   string
+}
+
+var (
+	SUCCESS = Response{Status: http.StatusOK, Body: "success"}
+	FAILURE = Response{Status: http.StatusInternalServerError, Body: "fail"}
+)
+
+// 可编排的通知接收方: 依次使用 Enqueue 的返回, 用完后使用默认返回 (SetDefault, 初始为 SUCCESS)
+type Receiver struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	clock     *Clock
+	responses []Response
+	fallback  Response
+	requests  []Request
+}
+
+func NewReceiver(clock *Clock) *Receiver {
+	r := &Receiver{clock: clock, fallback: SUCCESS}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+func (r *Receiver) serve(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	r.mu.Lock()
+	r.requests = append(r.requests, Request{At: r.clock.Now(), Path: req.URL.Path, Header: req.Header, Body: string(body)})
+	response := r.fallback
+	if len(r.responses) > 0 {
+		response, r.responses = r.responses[0], r.responses[1:]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(response.Status)
+	w.Write([]byte(response.Body))
+}
+
+// 依次返回 responses
+func (r *Receiver) Enqueue(responses ...Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, responses...)
+}
+
+// 设置 Enqueue 的返回用完后的默认返回
+func (r *Receiver) SetDefault(response Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = response
+}
+
+// 已收到的请求
+func (r *Receiver) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request{}, r.requests...)
+}
+
+// 收到请求的时间, 相对于 start
+func (r *Receiver) Timeline(start time.Time) []time.Duration {
+	requests := r.Requests()
+	timeline := make([]time.Duration, len(requests))
+	for i, req := range requests {
+		timeline[i] = req.At.Sub(start)
+	}
+	return timeline
+}
@@ -0,0 +1,128 @@
+package notification
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafka header 转为通知地址的 header
+type HeaderMapping struct {
+	Kafka string // kafka header 名称, 区分大小写
+	Http  string // 发送时的 header 名称, 为空时与 Kafka 相同
+}
+
+// kafka headers 的使用规则
+// 消息 meta 中已指定的 event, tenant, channel 和 headers 优先
+type HeaderConfig struct {
+	Mappings []HeaderMapping
+	Event    string // 作为 meta.event 的 kafka header, 有值时按订阅发送
+	Tenant   string // 作为 meta.tenant 的 kafka header
+	Channel  string // 作为 meta.channel 的 kafka header
+	Read     bool   // 没有以上规则时也读取 kafka headers, 供 DeliveryChecker 使用
+}
+
+var headerRules = struct {
+	sync.RWMutex
+	config HeaderConfig
+}{}
+
+// 设置 kafka headers 的使用规则, 由启动程序根据配置设置
+func SetHeaderConfig(config HeaderConfig) {
+	headerRules.Lock()
+	defer headerRules.Unlock()
+	headerRules.config = config
+}
+
+func getHeaderConfig() HeaderConfig {
+	headerRules.RLock()
+	defer headerRules.RUnlock()
+	return headerRules.config
+}
+
+// 是否需要读取 kafka 消息 headers
+func (c HeaderConfig) enabled() bool {
+	return c.Read || len(c.Mappings) > 0 || c.Event != "" || c.Tenant != "" || c.Channel != ""
+}
+
+// kafka consumer 的版本: 0.10 以上的版本才有消息时间, delay 和 ttl 需要用到;
+// 配置了 kafka headers 规则或开启了链路追踪时需要读取消息 headers, 使用 0.11
+func ConsumerVersion() sarama.KafkaVersion {
+	if getHeaderConfig().enabled() || TracingEnabled() {
+		return sarama.V0_11_0_0
+	}
+	return sarama.V0_10_0_0
+}
+
+// kafka 消息 headers, 同名的取最后一个
+func kafkaHeaders(msg *sarama.ConsumerMessage) map[string]string {
+	if len(msg.Headers) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return headers
+}
+
+// 按规则用 kafka headers 补充 meta 中没有指定的 event, tenant 和 channel
+func routeByHeaders(meta *MessageMeta, headers map[string]string) {
+	config := getHeaderConfig()
+	if meta.Event == "" && config.Event != "" {
+		meta.Event = headers[config.Event]
+	}
+	if meta.Tenant == "" && config.Tenant != "" {
+		meta.Tenant = headers[config.Tenant]
+	}
+	if meta.Channel == "" && config.Channel != "" {
+		meta.Channel = headers[config.Channel]
+	}
+}
+
+// 按规则将 kafka headers 加入通知地址的 headers, 通知地址中已有的 header 优先
+// 通知地址的 headers 不是 json 对象时不改变, 由发送通道报错
+func mapHeaders(destination Destination, headers map[string]string) Destination {
+	mappings := getHeaderConfig().Mappings
+	if len(mappings) == 0 || len(headers) == 0 {
+		return destination
+	}
+	merged := map[string]string{}
+	if destination.Headers != "" {
+		if err := json.Unmarshal([]byte(destination.Headers), &merged); err != nil {
+			return destination
+		}
+	}
+	exists := make(map[string]bool, len(merged))
+	for k := range merged {
+		exists[http.CanonicalHeaderKey(k)] = true
+	}
+	changed := false
+	for _, mapping := range mappings {
+		value, ok := headers[mapping.Kafka]
+		if !ok {
+			continue
+		}
+		name := mapping.Http
+		if name == "" {
+			name = mapping.Kafka
+		}
+		if exists[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		merged[name] = value
+		exists[http.CanonicalHeaderKey(name)] = true
+		changed = true
+	}
+	if !changed {
+		return destination
+	}
+	if b, err := json.Marshal(merged); err == nil {
+		destination.Headers = string(b)
+	}
+	return destination
+}
@@ -0,0 +1,81 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	SetHeaderConfig(HeaderConfig{
+		Mappings: []HeaderMapping{{Kafka: "x-request-id", Http: "X-Request-Id"}, {Kafka: "dealer-id"}},
+		Event:    "event-type",
+		Tenant:   "tenant-id",
+		Channel:  "channel",
+	})
+	defer SetHeaderConfig(HeaderConfig{})
+	assert.Equal(sarama.V0_11_0_0, ConsumerVersion())
+
+	msg := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{
+		{Key: []byte("x-request-id"), Value: []byte("r1")},
+		{Key: []byte("dealer-id"), Value: []byte("123")},
+		{Key: []byte("event-type"), Value: []byte("order.paid")},
+		{Key: []byte("tenant-id"), Value: []byte("t1")},
+	}}
+	headers := kafkaHeaders(msg)
+	assert.Equal("r1", headers["x-request-id"])
+
+	// meta 中已指定的优先
+	meta := MessageMeta{Tenant: "t2"}
+	routeByHeaders(&meta, headers)
+	assert.Equal("order.paid", meta.Event)
+	assert.Equal("t2", meta.Tenant)
+	assert.Equal("", meta.Channel)
+
+	destination := mapHeaders(Destination{Url: "http://localhost", Headers: `{"Dealer-Id":"456"}`}, headers)
+	assert.JSONEq(`{"Dealer-Id":"456","X-Request-Id":"r1"}`, destination.Headers)
+	destination = mapHeaders(Destination{Url: "http://localhost"}, headers)
+	assert.JSONEq(`{"dealer-id":"123","X-Request-Id":"r1"}`, destination.Headers)
+	destination = mapHeaders(Destination{Url: "http://localhost", Headers: `[1]`}, headers)
+	assert.Equal(`[1]`, destination.Headers)
+	destination = mapHeaders(Destination{Url: "http://localhost"}, nil)
+	assert.Equal("", destination.Headers)
+
+	SetHeaderConfig(HeaderConfig{})
+	assert.Equal(sarama.V0_10_0_0, ConsumerVersion())
+	meta = MessageMeta{}
+	routeByHeaders(&meta, headers)
+	assert.Equal("", meta.Event)
+}
+
+func TestDeliveryChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	// 返回中的 request_id 与 kafka header 一致时成功
+	RegisterDeliveryChecker("echo", func(delivery Delivery, statusCode int, result string) bool {
+		data, err := decodeObject(result)
+		return err != nil || data["request_id"] != delivery.Headers["x-request-id"]
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"request_id":"` + r.Header.Get("X-Request-Id") + `"}`))
+	}))
+	defer server.Close()
+
+	delivery := Delivery{
+		Content:     `{"foo":"bar"}`,
+		Destination: Destination{Url: server.URL, Headers: `{"X-Request-Id":"r1"}`, Checker: "echo"},
+		Headers:     map[string]string{"x-request-id": "r1"},
+	}
+	assert.Equal(SEND_DELIVERED, httpChannel{}.Send(context.Background(), delivery).Status)
+	delivery.Headers["x-request-id"] = "r2"
+	assert.Equal(SEND_RETRY, httpChannel{}.Send(context.Background(), delivery).Status)
+
+	// 没有注册为 DeliveryChecker 的使用 Checker
+	assert.False(getDeliveryChecker(CHECKER_STATUS)(Delivery{}, 204, ""))
+}
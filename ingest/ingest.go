@@ -0,0 +1,79 @@
+// 通知接入程序: 通过 HTTP 接收通知并发送到 kafka, 供不能直接访问 kafka 的服务使用
+package main
+
+import (
+	client "../client"
+	config "../config"
+	server "./server"
+
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+var (
+	brokers  = flag.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster")
+	topic    = flag.String("topic", "", "REQUIRED: the topic to produce to")
+	verbose  = flag.Bool("verbose", false, "Whether to turn on sarama logging")
+	httpAddr = flag.String("http", ":8090", "The address to serve the ingestion API on")
+)
+
+func init() {
+	flag.Parse()
+
+	if *brokers == "" {
+		printUsageErrorAndExit("You have to provide -brokers as a comma-separated list, or set the KAFKA_PEERS environment variable.")
+	}
+	if *topic == "" {
+		printUsageErrorAndExit("-topic is required")
+	}
+	if len(config.MyConfig.Ingest.Keys) == 0 {
+		printUsageErrorAndExit("ingest.keys is required in config")
+	}
+	if *verbose {
+		sarama.Logger = log.New(os.Stderr, "ingest ", log.LstdFlags)
+	}
+}
+
+func main() {
+	publisher, err := client.NewSyncPublisher(strings.Split(*brokers, ","), *topic)
+	if err != nil {
+		printErrorAndExit(69, "Failed to start producer: %s", err)
+	}
+	defer publisher.Close()
+
+	var keys []server.ApiKey
+	for _, key := range config.MyConfig.Ingest.Keys {
+		keys = append(keys, server.ApiKey{Name: key.Name, Key: key.Key})
+	}
+	handler := server.NewIngestHandler(publisher, server.IngestConfig{
+		Keys:     keys,
+		MaxBytes: config.MyConfig.Ingest.Maxbytes,
+		MaxBatch: config.MyConfig.Ingest.Maxbatch,
+	})
+
+	glog.Infof("ingestion API listening on %s, topic=%s", *httpAddr, *topic)
+	if err := http.ListenAndServe(*httpAddr, handler); err != nil {
+		printErrorAndExit(69, "Failed to serve ingestion API: %s", err)
+	}
+}
+
+func printErrorAndExit(code int, format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	os.Exit(code)
+}
+
+func printUsageErrorAndExit(format string, values ...interface{}) {
+	fmt.Fprintf(os.Stderr, "ERROR: %s\n", fmt.Sprintf(format, values...))
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Available command line options:")
+	flag.PrintDefaults()
+	os.Exit(64)
+}
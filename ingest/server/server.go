@@ -0,0 +1,170 @@
+// HTTP 接入接口: 接收通知, 检查后通过 client.Publisher 发送到 kafka, 由 bin/ingest 使用
+package server
+
+import (
+	notification "../.."
+	client "../../client"
+
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+const (
+	HEADER_API_KEY = notification.HEADER_API_KEY
+
+	DEFAULT_MAX_BYTES = 1 << 20 // 请求体默认最大 1MB
+	DEFAULT_MAX_BATCH = 100     // 一次默认最多 100 条通知
+)
+
+// 接入接口的生产者, 每个生产者一个 api key
+type ApiKey struct {
+	Name string
+	Key  string
+}
+
+// 接入接口的配置
+type IngestConfig struct {
+	Keys     []ApiKey
+	MaxBytes int64 // 请求体最大字节数, 0 表示 DEFAULT_MAX_BYTES
+	MaxBatch int   // 一次最多通知数, 0 表示 DEFAULT_MAX_BATCH
+}
+
+// 接入接口中的一条通知, key 为 kafka 消息 key (可选)
+type IngestMessage struct {
+	notification.Message
+	Key string `json:"key"`
+}
+
+type ingestError struct {
+	Error string   `json:"error"`
+	Index *int     `json:"index,omitempty"` // 批量发送时出错的通知序号
+	Ids   []string `json:"ids,omitempty"`   // 批量发送时出错之前已写入 kafka 的通知 id, 重试时从 index 开始
+}
+
+type ingestHandler struct {
+	publisher *client.Publisher
+	config    IngestConfig
+}
+
+// HTTP 接入接口, 检查后发送到 publisher 的 topic, publisher 应为同步发送
+//
+// POST /v1/notifications
+// X-Api-Key: <key> (或 Authorization: Bearer <key>)
+//
+// 请求体为一条通知 {"content": "...", "meta": {...}, "key": "..."} 时返回 {"id": "..."}
+// 为通知数组时返回 {"ids": [...]}, 任何一条无效时都不发送
+// 批量发送不是原子的, 第 index 条写入失败时返回 503 {"error": "...", "index": index, "ids": [之前已写入的 id]}
+func NewIngestHandler(publisher *client.Publisher, config IngestConfig) http.Handler {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DEFAULT_MAX_BYTES
+	}
+	if config.MaxBatch <= 0 {
+		config.MaxBatch = DEFAULT_MAX_BATCH
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/v1/notifications", &ingestHandler{publisher: publisher, config: config})
+	return mux
+}
+
+func (h *ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fn := "ingestHandler.ServeHTTP"
+
+	if r.Method != "POST" {
+		writeIngestError(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	producer, ok := h.authenticate(r)
+	if !ok {
+		writeIngestError(w, http.StatusUnauthorized, "invalid api key", nil)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, h.config.MaxBytes))
+	if err != nil {
+		writeIngestError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body too large, max %d bytes", h.config.MaxBytes), nil)
+		return
+	}
+
+	var (
+		messages []IngestMessage
+		batch    = strings.HasPrefix(strings.TrimSpace(string(body)), "[")
+	)
+	if batch {
+		err = json.Unmarshal(body, &messages)
+	} else {
+		messages = make([]IngestMessage, 1)
+		err = json.Unmarshal(body, &messages[0])
+	}
+	if err != nil {
+		writeIngestError(w, http.StatusBadRequest, "invalid json: "+err.Error(), nil)
+		return
+	}
+	if len(messages) == 0 {
+		writeIngestError(w, http.StatusBadRequest, "no notification", nil)
+		return
+	}
+	if len(messages) > h.config.MaxBatch {
+		writeIngestError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("too many notifications, max %d", h.config.MaxBatch), nil)
+		return
+	}
+	for i := range messages {
+		if err = messages[i].Validate(); err != nil {
+			writeIngestError(w, http.StatusBadRequest, err.Error(), index(batch, i))
+			return
+		}
+	}
+
+	ids := make([]string, 0, len(messages))
+	for i := range messages {
+		id, err := h.publisher.Publish(&messages[i].Message, messages[i].Key)
+		if err != nil {
+			glog.Errorf("@%s, publish failed, err=%s, producer=%s, index=%d", fn, err, producer, i)
+			// 之前的通知已写入 kafka, 返回它们的 id, 调用方只需从 index 开始重试
+			writeIngestJson(w, http.StatusServiceUnavailable, ingestError{Error: "publish failed: " + err.Error(), Index: index(batch, i), Ids: ids})
+			return
+		}
+		ids = append(ids, id)
+	}
+	glog.Infof("@%s, accepted, producer=%s, ids=%v", fn, producer, ids)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if batch {
+		json.NewEncoder(w).Encode(map[string][]string{"ids": ids})
+	} else {
+		json.NewEncoder(w).Encode(map[string]string{"id": ids[0]})
+	}
+}
+
+// 返回 api key 对应的生产者名称
+func (h *ingestHandler) authenticate(r *http.Request) (producer string, ok bool) {
+	key := notification.RequestApiKey(r)
+	for _, apiKey := range h.config.Keys {
+		if notification.MatchApiKey(key, []string{apiKey.Key}) {
+			return apiKey.Name, true
+		}
+	}
+	return
+}
+
+func index(batch bool, i int) *int {
+	if !batch {
+		return nil
+	}
+	return &i
+}
+
+func writeIngestError(w http.ResponseWriter, statusCode int, message string, index *int) {
+	writeIngestJson(w, statusCode, ingestError{Error: message, Index: index})
+}
+
+func writeIngestJson(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	client "../../client"
+
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func ingest(handler http.Handler, key string, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest("POST", "/v1/notifications", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HEADER_API_KEY, key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	var res map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res
+}
+
+func TestIngestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	producer := mocks.NewSyncProducer(t, nil)
+	publisher := client.NewSyncPublisherWithProducer(producer, "mytopic")
+	defer publisher.Close()
+	handler := NewIngestHandler(publisher, IngestConfig{
+		Keys:     []ApiKey{{Name: "billing", Key: "secret"}},
+		MaxBytes: 512,
+		MaxBatch: 2,
+	})
+	valid := `{"content":"{\"foo\":\"bar\"}","meta":{"url":"http://localhost:8000/printall"},"key":"k1"}`
+
+	code, _ := ingest(handler, "", valid)
+	assert.Equal(http.StatusUnauthorized, code)
+	code, _ = ingest(handler, "wrong", valid)
+	assert.Equal(http.StatusUnauthorized, code)
+
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(value []byte) error {
+		assert.Contains(string(value), `"url":"http://localhost:8000/printall"`)
+		return nil
+	})
+	code, res := ingest(handler, "secret", valid)
+	assert.Equal(http.StatusAccepted, code)
+	assert.Len(res["id"], 36)
+
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndSucceed()
+	code, res = ingest(handler, "secret", "["+valid+","+`{"content":"{}","meta":{"id":"b","event":"order.paid"}}`+"]")
+	assert.Equal(http.StatusAccepted, code)
+	assert.Len(res["ids"], 2)
+	assert.Equal("b", res["ids"].([]interface{})[1])
+
+	// 无效的通知, 整批都不发送
+	code, res = ingest(handler, "secret", "["+valid+","+`{"content":"{}","meta":{}}`+"]")
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal(float64(1), res["index"])
+
+	code, _ = ingest(handler, "secret", "["+valid+","+valid+","+valid+"]")
+	assert.Equal(http.StatusRequestEntityTooLarge, code)
+	code, _ = ingest(handler, "secret", `{"content":"`+strings.Repeat("a", 600)+`"}`)
+	assert.Equal(http.StatusRequestEntityTooLarge, code)
+	code, _ = ingest(handler, "secret", `not json`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	code, _ = ingest(handler, "secret", valid)
+	assert.Equal(http.StatusServiceUnavailable, code)
+
+	// 批量发送中途失败, 返回已写入的 id 和出错的序号
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+	code, res = ingest(handler, "secret", "["+`{"content":"{}","meta":{"id":"a","event":"order.paid"}}`+","+valid+"]")
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal(float64(1), res["index"])
+	assert.Equal([]interface{}{"a"}, res["ids"])
+}
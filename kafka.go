@@ -0,0 +1,220 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	neturl "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+// 转发到 kafka, 通知地址为 kafka://<集群>/<topic>, 集群在 config.yaml 的 forward.clusters 中配置
+// 可选参数 key 指定消息 key: 为空时使用原消息的 key, event, tenant, none (没有 key) 或 json:<字段> (取 content 中的字段)
+// headers 作为 kafka 消息的 headers, 另外附带原消息的 topic, partition, offset 和事件
+const (
+	CHANNEL_KAFKA = "kafka"
+
+	FORWARD_KEY_EVENT       = "event"
+	FORWARD_KEY_TENANT      = "tenant"
+	FORWARD_KEY_NONE        = "none"
+	FORWARD_KEY_JSON_PREFIX = "json:"
+
+	E_NOT_KAFKA       = "The url of kafka destination must be kafka://cluster/topic"
+	E_UNKNOWN_CLUSTER = "Unknown kafka cluster"
+)
+
+// 转发的目标集群
+type ForwardCluster struct {
+	Name    string
+	Brokers []string
+}
+
+type forwarder struct {
+	mu        sync.Mutex
+	clusters  map[string][]string
+	producers map[string]sarama.SyncProducer
+	// 创建 producer, 测试时替换
+	newProducer func(brokers []string, config *sarama.Config) (sarama.SyncProducer, error)
+}
+
+var forwards = &forwarder{
+	clusters:    map[string][]string{},
+	producers:   map[string]sarama.SyncProducer{},
+	newProducer: sarama.NewSyncProducer,
+}
+
+func init() {
+	channels[CHANNEL_KAFKA] = forwards
+}
+
+// 设置转发的目标集群, 由启动程序根据配置设置, producer 在第一次转发时创建
+func SetForwardClusters(clusters []ForwardCluster) {
+	forwards.mu.Lock()
+	defer forwards.mu.Unlock()
+	forwards.closeProducers()
+	forwards.clusters = make(map[string][]string, len(clusters))
+	for _, cluster := range clusters {
+		forwards.clusters[cluster.Name] = cluster.Brokers
+	}
+}
+
+// 关闭所有 producer, 程序退出前调用
+func CloseForwarders() {
+	forwards.mu.Lock()
+	defer forwards.mu.Unlock()
+	forwards.closeProducers()
+}
+
+func (f *forwarder) closeProducers() {
+	fn := "forwarder.closeProducers"
+	for name, producer := range f.producers {
+		if err := producer.Close(); err != nil {
+			glog.Errorf("@%s, producer.Close failed, err=%s, cluster=%s", fn, err, name)
+		}
+		delete(f.producers, name)
+	}
+}
+
+func (f *forwarder) Check(destination Destination) (err error) {
+	_, _, _, err = parseKafkaUrl(destination.Url)
+	return
+}
+
+// 同步发送到目标 topic, 发送失败按重试处理, 消息过大或没有权限时不再重试
+func (f *forwarder) Send(ctx context.Context, delivery Delivery) (outcome Outcome) {
+	fn := "forwarder.Send"
+
+	destination := delivery.Destination
+	cluster, topic, keyMode, err := parseKafkaUrl(destination.Url)
+	if err != nil {
+		outcome.Status = SEND_PERMANENT
+		outcome.Err = err
+		return
+	}
+	producer, err := f.producer(cluster)
+	if err != nil {
+		glog.Errorf("@%s, create producer failed, err=%s, cluster=%s", fn, err, cluster)
+		outcome.Status = SEND_RETRY
+		if err.Error() == E_UNKNOWN_CLUSTER {
+			outcome.Status = SEND_PERMANENT
+		}
+		outcome.Err = err
+		return
+	}
+	message, err := forwardMessage(delivery, topic, keyMode)
+	if err != nil {
+		outcome.Status = SEND_PERMANENT
+		outcome.Err = err
+		return
+	}
+
+	partition, offset, err := producer.SendMessage(message)
+	if err != nil {
+		glog.Errorf("@%s, producer.SendMessage failed, err=%s, cluster=%s, topic=%s", fn, err, cluster, topic)
+		outcome.Status = SEND_RETRY
+		switch err {
+		case sarama.ErrMessageSizeTooLarge, sarama.ErrInvalidMessage, sarama.ErrTopicAuthorizationFailed, sarama.ErrInvalidTopic:
+			outcome.Status = SEND_PERMANENT
+		}
+		outcome.Err = err
+		return
+	}
+	outcome.Status = SEND_DELIVERED
+	outcome.Result = topic + "/" + strconv.Itoa(int(partition)) + "/" + strconv.FormatInt(offset, 10)
+	return
+}
+
+// 每个集群一个幂等 producer
+func (f *forwarder) producer(cluster string) (producer sarama.SyncProducer, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if producer, ok := f.producers[cluster]; ok {
+		return producer, nil
+	}
+	brokers, ok := f.clusters[cluster]
+	if !ok {
+		return nil, errors.New(E_UNKNOWN_CLUSTER)
+	}
+
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_11_0_0 // 幂等 producer 和消息 headers 需要 0.11 以上版本
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Return.Successes = true
+	config.Net.MaxOpenRequests = 1
+	if producer, err = f.newProducer(brokers, config); err != nil {
+		return
+	}
+	f.producers[cluster] = producer
+	return
+}
+
+func parseKafkaUrl(url string) (cluster string, topic string, keyMode string, err error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return
+	}
+	topic = strings.Trim(u.Path, "/")
+	if u.Scheme != "kafka" || u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+		err = errors.New(E_NOT_KAFKA)
+		return
+	}
+	return u.Host, topic, u.Query().Get("key"), nil
+}
+
+func forwardMessage(delivery Delivery, topic string, keyMode string) (message *sarama.ProducerMessage, err error) {
+	message = &sarama.ProducerMessage{Topic: topic, Value: sarama.StringEncoder(delivery.Content)}
+
+	var key string
+	switch {
+	case keyMode == "":
+		key = delivery.Key
+	case keyMode == FORWARD_KEY_EVENT:
+		key = delivery.Event
+	case keyMode == FORWARD_KEY_TENANT:
+		key = delivery.Tenant
+	case keyMode == FORWARD_KEY_NONE:
+	case strings.HasPrefix(keyMode, FORWARD_KEY_JSON_PREFIX):
+		var data map[string]interface{}
+		if data, err = decodeObject(delivery.Content); err != nil {
+			return
+		}
+		key = scalarString(data[strings.TrimPrefix(keyMode, FORWARD_KEY_JSON_PREFIX)])
+	default:
+		err = errors.New("unknown key: " + keyMode)
+		return
+	}
+	if key != "" {
+		message.Key = sarama.StringEncoder(key)
+	}
+
+	headers := map[string]string{}
+	if delivery.Destination.Headers != "" {
+		if err = json.Unmarshal([]byte(delivery.Destination.Headers), &headers); err != nil {
+			return
+		}
+	}
+	headers["notification-topic"] = delivery.Topic
+	headers["notification-partition"] = strconv.Itoa(int(delivery.Partition))
+	headers["notification-offset"] = strconv.FormatInt(delivery.Offset, 10)
+	if delivery.Event != "" {
+		headers["notification-event"] = delivery.Event
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		message.Headers = append(message.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(headers[k])})
+	}
+	return
+}
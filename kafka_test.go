@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster, topic, keyMode, err := parseKafkaUrl("kafka://partner/orders?key=json:order_id")
+	assert.Nil(err)
+	assert.Equal("partner", cluster)
+	assert.Equal("orders", topic)
+	assert.Equal("json:order_id", keyMode)
+	_, _, _, err = parseKafkaUrl("kafka://partner")
+	assert.NotNil(err)
+
+	delivery := Delivery{
+		Content:     `{"order_id":123}`,
+		Destination: Destination{Headers: `{"source":"notification"}`},
+		Topic:       "mytopic",
+		Partition:   1,
+		Offset:      10,
+		Key:         "k",
+	}
+	message, err := forwardMessage(delivery, topic, keyMode)
+	assert.Nil(err)
+	assert.Equal(sarama.StringEncoder("123"), message.Key)
+	assert.Equal([]sarama.RecordHeader{
+		{Key: []byte("notification-offset"), Value: []byte("10")},
+		{Key: []byte("notification-partition"), Value: []byte("1")},
+		{Key: []byte("notification-topic"), Value: []byte("mytopic")},
+		{Key: []byte("source"), Value: []byte("notification")},
+	}, message.Headers)
+
+	message, err = forwardMessage(delivery, topic, "")
+	assert.Nil(err)
+	assert.Equal(sarama.StringEncoder("k"), message.Key)
+	message, err = forwardMessage(delivery, topic, FORWARD_KEY_NONE)
+	assert.Nil(err)
+	assert.Nil(message.Key)
+}
+
+func TestForwarder(t *testing.T) {
+	assert := assert.New(t)
+
+	var producer *mocks.SyncProducer
+	f := &forwarder{
+		clusters:  map[string][]string{"partner": {"127.0.0.1:9092"}},
+		producers: map[string]sarama.SyncProducer{},
+		newProducer: func(brokers []string, config *sarama.Config) (sarama.SyncProducer, error) {
+			assert.True(config.Producer.Idempotent)
+			producer = mocks.NewSyncProducer(t, config)
+			producer.ExpectSendMessageAndFail(sarama.ErrNotEnoughReplicas)
+			producer.ExpectSendMessageAndFail(sarama.ErrMessageSizeTooLarge)
+			producer.ExpectSendMessageAndSucceed()
+			return producer, nil
+		},
+	}
+	delivery := Delivery{Content: "hello", Destination: Destination{Channel: CHANNEL_KAFKA, Url: "kafka://partner/orders"}}
+
+	assert.Equal(SEND_RETRY, f.Send(context.Background(), delivery).Status)
+	assert.Equal(SEND_PERMANENT, f.Send(context.Background(), delivery).Status)
+	outcome := f.Send(context.Background(), delivery)
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.Nil(outcome.Err)
+
+	delivery.Destination.Url = "kafka://unknown/orders"
+	assert.Equal(SEND_PERMANENT, f.Send(context.Background(), delivery).Status)
+	assert.Nil(producer.Close())
+}
@@ -0,0 +1,279 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// 日志级别, 只输出不高于配置级别的日志
+type LogLevel int
+
+const (
+	LOG_ERROR LogLevel = iota
+	LOG_WARNING
+	LOG_INFO
+	LOG_DEBUG // 输出请求体和响应体
+)
+
+const (
+	REDACTED = "[REDACTED]"
+
+	// 以下字段为请求体或响应体, 只在 LOG_DEBUG 级别输出
+	FIELD_CONTENT  = "content"
+	FIELD_BODY     = "body"
+	FIELD_RESPONSE = "response"
+	// headers 字段按 RedactHeaders 隐藏, 可以是 json 字符串, map[string]string 或 http.Header
+	FIELD_HEADERS = "headers"
+)
+
+var (
+	DEFAULT_REDACT_HEADERS = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", HEADER_SIGNATURE}
+	DEFAULT_REDACT_FIELDS  = []string{"data", "password", "secret", "token", "sign"}
+
+	logLevelNames = []string{"error", "warning", "info", "debug"}
+)
+
+func (l LogLevel) String() string {
+	if l < LOG_ERROR || l > LOG_DEBUG {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+// 解析配置中的日志级别, 为空时为 info
+func ParseLogLevel(s string) (level LogLevel, err error) {
+	if s == "" {
+		return LOG_INFO, nil
+	}
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return LOG_INFO, fmt.Errorf("unknown log level: %s", s)
+}
+
+// 日志字段
+type Fields map[string]interface{}
+
+// 结构化日志, msg 为简短的描述, 如 "send success", 其他信息放在 fields 中
+type Logger interface {
+	Log(level LogLevel, msg string, fields Fields)
+}
+
+type LogConfig struct {
+	Level         LogLevel
+	RedactHeaders []string // 隐藏的 header, 不区分大小写, 为空时使用 DEFAULT_REDACT_HEADERS
+	RedactFields  []string // 隐藏的请求体和响应体字段 (json 任意层级或 form), 不区分大小写, 为空时使用 DEFAULT_REDACT_FIELDS
+}
+
+var (
+	logger   Logger = NewGlogLogger(LogConfig{Level: LOG_INFO, RedactHeaders: DEFAULT_REDACT_HEADERS, RedactFields: DEFAULT_REDACT_FIELDS})
+	loggerMu sync.RWMutex
+)
+
+// 替换日志, 由启动程序根据配置设置
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+func logf(level LogLevel, msg string, fields Fields) {
+	loggerMu.RLock()
+	l := logger
+	loggerMu.RUnlock()
+	l.Log(level, msg, fields)
+}
+
+// 每条日志为一行 json: {"time":...,"level":...,"msg":...,<fields>}
+type jsonLogger struct {
+	config  LogConfig
+	headers map[string]bool
+	fields  map[string]bool
+	output  func(level LogLevel, line []byte)
+}
+
+// 输出到 w, 每行一条日志
+func NewJSONLogger(w io.Writer, config LogConfig) Logger {
+	var mu sync.Mutex
+	return newJSONLogger(config, func(level LogLevel, line []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(append(line, '\n'))
+	})
+}
+
+// 按级别输出到 glog, 沿用 -log_dir 等参数
+func NewGlogLogger(config LogConfig) Logger {
+	return newJSONLogger(config, func(level LogLevel, line []byte) {
+		switch level {
+		case LOG_ERROR:
+			glog.ErrorDepth(3, string(line))
+		case LOG_WARNING:
+			glog.WarningDepth(3, string(line))
+		default:
+			glog.InfoDepth(3, string(line))
+		}
+	})
+}
+
+// 按 output 创建日志: 为空时输出到 glog, 或 stdout, stderr, 文件路径 (追加)
+func OpenLogger(output string, config LogConfig) (l Logger, err error) {
+	switch output {
+	case "":
+		return NewGlogLogger(config), nil
+	case "stdout":
+		return NewJSONLogger(os.Stdout, config), nil
+	case "stderr":
+		return NewJSONLogger(os.Stderr, config), nil
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	return NewJSONLogger(f, config), nil
+}
+
+func newJSONLogger(config LogConfig, output func(level LogLevel, line []byte)) *jsonLogger {
+	// 配置中缺少时不能关闭隐藏
+	if len(config.RedactHeaders) == 0 {
+		config.RedactHeaders = DEFAULT_REDACT_HEADERS
+	}
+	if len(config.RedactFields) == 0 {
+		config.RedactFields = DEFAULT_REDACT_FIELDS
+	}
+	l := &jsonLogger{config: config, headers: map[string]bool{}, fields: map[string]bool{}, output: output}
+	for _, h := range config.RedactHeaders {
+		l.headers[strings.ToLower(h)] = true
+	}
+	for _, f := range config.RedactFields {
+		l.fields[strings.ToLower(f)] = true
+	}
+	return l
+}
+
+func (l *jsonLogger) Log(level LogLevel, msg string, fields Fields) {
+	if level > l.config.Level {
+		return
+	}
+	entry := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		switch k {
+		case FIELD_CONTENT, FIELD_BODY, FIELD_RESPONSE:
+			if l.config.Level < LOG_DEBUG {
+				continue
+			}
+			v = l.redactBody(fmt.Sprint(v))
+		case FIELD_HEADERS:
+			v = l.redactHeaders(v)
+		}
+		if e, ok := v.(error); ok {
+			v = e.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = time.Now().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg, "error": err.Error()})
+	}
+	l.output(level, line)
+}
+
+func (l *jsonLogger) redactHeaders(v interface{}) interface{} {
+	var headers map[string]string
+	switch h := v.(type) {
+	case string:
+		if h == "" {
+			return h
+		}
+		if err := json.Unmarshal([]byte(h), &headers); err != nil {
+			return REDACTED
+		}
+	case map[string]string:
+		headers = h
+	case http.Header:
+		headers = make(map[string]string, len(h))
+		for k := range h {
+			headers[k] = h.Get(k)
+		}
+	default:
+		return REDACTED
+	}
+	redacted := make(map[string]string, len(headers))
+	for k, value := range headers {
+		if l.headers[strings.ToLower(k)] {
+			value = REDACTED
+		}
+		redacted[k] = value
+	}
+	return redacted
+}
+
+// json 按字段名隐藏 (任意层级), form 按参数名隐藏, 其他格式原样输出
+func (l *jsonLogger) redactBody(body string) string {
+	if len(l.fields) == 0 || body == "" {
+		return body
+	}
+	var obj interface{}
+	if err := json.Unmarshal([]byte(body), &obj); err == nil {
+		if b, err := json.Marshal(l.redactValue(obj)); err == nil {
+			return string(b)
+		}
+		return body
+	}
+	if strings.Contains(body, "=") && !strings.ContainsAny(body, " \n{") {
+		if values, err := neturl.ParseQuery(body); err == nil {
+			for k := range values {
+				if l.fields[strings.ToLower(k)] {
+					values.Set(k, REDACTED)
+				}
+			}
+			return values.Encode()
+		}
+	}
+	return body
+}
+
+func (l *jsonLogger) redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, item := range value {
+			if l.fields[strings.ToLower(k)] {
+				value[k] = REDACTED
+			} else {
+				value[k] = l.redactValue(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = l.redactValue(item)
+		}
+	}
+	return v
+}
+
+// 返回增加了 key, value 的副本
+func (f Fields) With(keyValues ...interface{}) Fields {
+	fields := make(Fields, len(f)+len(keyValues)/2)
+	for k, v := range f {
+		fields[k] = v
+	}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		fields[fmt.Sprint(keyValues[i])] = keyValues[i+1]
+	}
+	return fields
+}
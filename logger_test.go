@@ -0,0 +1,89 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func decodeLines(buf *bytes.Buffer) (entries []map[string]interface{}) {
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		json.Unmarshal([]byte(line), &entry)
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func TestJSONLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	config := LogConfig{Level: LOG_INFO, RedactHeaders: DEFAULT_REDACT_HEADERS, RedactFields: []string{"data", "sign"}}
+	l := NewJSONLogger(&buf, config)
+	fields := Fields{"topic": "mytopic", "offset": int64(7)}
+
+	l.Log(LOG_DEBUG, "kafka message", fields)
+	l.Log(LOG_INFO, "send success", fields.With("err", errors.New("boom"), FIELD_CONTENT, `{"data":"xxx"}`, FIELD_HEADERS, `{"Authorization":"Bearer t","dealer-id":"1"}`))
+	entries := decodeLines(&buf)
+	assert.Len(entries, 1)
+	assert.Equal("info", entries[0]["level"])
+	assert.Equal("send success", entries[0]["msg"])
+	assert.Equal("mytopic", entries[0]["topic"])
+	assert.Equal(float64(7), entries[0]["offset"])
+	assert.Equal("boom", entries[0]["err"])
+	assert.NotContains(entries[0], FIELD_CONTENT)
+	assert.Equal(map[string]interface{}{"Authorization": REDACTED, "dealer-id": "1"}, entries[0][FIELD_HEADERS])
+	// With 返回副本
+	assert.NotContains(fields, "err")
+
+	// debug 级别输出请求体, 按字段隐藏
+	buf.Reset()
+	config.Level = LOG_DEBUG
+	l = NewJSONLogger(&buf, config)
+	l.Log(LOG_DEBUG, "post request", Fields{
+		FIELD_BODY:     `{"data":"vcfF","mess":"939984059","items":[{"Sign":"bc33"}]}`,
+		FIELD_RESPONSE: "data=vcfF&mess=939984059",
+		FIELD_CONTENT:  "plain text",
+		FIELD_HEADERS:  http.Header{"X-Api-Key": {"k"}, "Content-Type": {"application/json"}},
+	})
+	entries = decodeLines(&buf)
+	assert.Len(entries, 1)
+	assert.JSONEq(`{"data":"[REDACTED]","mess":"939984059","items":[{"Sign":"[REDACTED]"}]}`, entries[0][FIELD_BODY].(string))
+	assert.Equal("data=%5BREDACTED%5D&mess=939984059", entries[0][FIELD_RESPONSE])
+	assert.Equal("plain text", entries[0][FIELD_CONTENT])
+	assert.Equal(map[string]interface{}{"X-Api-Key": REDACTED, "Content-Type": "application/json"}, entries[0][FIELD_HEADERS])
+
+	// 没有配置隐藏的 header 和字段时使用默认值
+	buf.Reset()
+	l = NewJSONLogger(&buf, LogConfig{Level: LOG_DEBUG})
+	l.Log(LOG_DEBUG, "post request", Fields{
+		FIELD_BODY:    `{"data":"vcfF","password":"p","mess":"939984059"}`,
+		FIELD_HEADERS: http.Header{"Authorization": {"Bearer t"}},
+	})
+	entries = decodeLines(&buf)
+	assert.Len(entries, 1)
+	assert.JSONEq(`{"data":"[REDACTED]","password":"[REDACTED]","mess":"939984059"}`, entries[0][FIELD_BODY].(string))
+	assert.Equal(map[string]interface{}{"Authorization": REDACTED}, entries[0][FIELD_HEADERS])
+}
+
+func TestParseLogLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	level, err := ParseLogLevel("")
+	assert.Nil(err)
+	assert.Equal(LOG_INFO, level)
+	level, err = ParseLogLevel("DEBUG")
+	assert.Nil(err)
+	assert.Equal(LOG_DEBUG, level)
+	_, err = ParseLogLevel("verbose")
+	assert.NotNil(err)
+}
@@ -4,14 +4,18 @@ import (
 	notification ".."
 	config "../config"
 
+	"context"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Shopify/sarama"
 	"github.com/go-redis/redis"
@@ -25,7 +29,14 @@ var (
 	offset      = flag.String("offset", "newest", "The offset to start with. Can be `oldest`, `newest`")
 	verbose     = flag.Bool("verbose", false, "Whether to turn on sarama logging")
 	bufferSize  = flag.Int("buffer-size", 256, "The buffer size of the message channel.")
+	httpAddr    = flag.String("http", "", "The address to serve metrics and admin endpoints on, e.g. :8080, disabled when empty")
+	shutdown    = flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for in-flight notifications on shutdown before cancelling them")
 	redisClient *redis.Client
+	store       notification.RetryStore
+
+	// 退出时等待正在发送的消息, 超过 shutdown-timeout 后取消, 未完成的通知放入重试列表
+	fireCtx, cancelFire = context.WithCancel(context.Background())
+	inflight            sync.WaitGroup
 )
 
 func init() {
@@ -51,6 +62,11 @@ func init() {
 	} else {
 		glog.Infof("PING redis output: %s", pong)
 	}
+	store = notification.NewRedisStore(redisClient)
+
+	if err := config.MyConfig.Apply("notification-listener", strings.Split(*brokers, ",")); err != nil {
+		printErrorAndExit(69, "Invalid config: %s", err)
+	}
 }
 
 func main() {
@@ -64,8 +80,21 @@ func main() {
 		initialOffset, _ = strconv.ParseInt(*offset, 10, 64)
 	}
 
+	if *httpAddr != "" {
+		http.Handle("/admin/", notification.NewAdminHandler(store, *topic))
+		go func() {
+			if err := http.ListenAndServe(*httpAddr, nil); err != nil {
+				printErrorAndExit(69, "Failed to serve metrics and admin endpoints: %s", err)
+			}
+		}()
+	}
+
+	// 0.10 以上的版本才有消息时间, delay 和 ttl 需要用到; 0.11 以上的版本才有消息 headers
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = notification.ConsumerVersion()
+
 	brokerList := strings.Split(*brokers, ",")
-	c, err := sarama.NewConsumer(brokerList, nil)
+	c, err := sarama.NewConsumer(brokerList, consumerConfig)
 	if err != nil {
 		printErrorAndExit(69, "Failed to start consumer: %s", err)
 	}
@@ -110,9 +139,16 @@ func main() {
 		}(pc)
 	}
 
+	dispatched := make(chan struct{})
 	go func() {
+		defer close(dispatched)
+		if notification.IsOrderedTopic(*topic) {
+			dispatchOrdered(messages)
+			return
+		}
 		for message := range messages {
-			go notification.Fire(redisClient, message, "", notification.MessageRetry{})
+			inflight.Add(1)
+			go fire(message)
 		}
 	}()
 
@@ -120,12 +156,71 @@ func main() {
 
 	glog.Info("Done consuming topic", *topic)
 	close(messages)
+	<-dispatched
+	waitInflight()
+	notification.FlushBatches()
+	notification.CloseForwarders()
+	notification.CloseStatusTopic()
+	notification.CloseTracing()
 
 	if err := c.Close(); err != nil {
 		glog.Info("Failed to close consumer: ", err)
 	}
 }
 
+// 顺序发送: 按 key 分配到固定的协程依次发送, 没有 key 的消息不保证顺序
+func dispatchOrdered(messages <-chan *sarama.ConsumerMessage) {
+	workers := config.MyConfig.Ordered.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	queues := make([]chan *sarama.ConsumerMessage, workers)
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, *bufferSize)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			for message := range queue {
+				fire(message)
+			}
+		}(queues[i])
+	}
+
+	for message := range messages {
+		inflight.Add(1)
+		if len(message.Key) == 0 {
+			go fire(message)
+			continue
+		}
+		h := fnv.New32a()
+		h.Write(message.Key)
+		queues[h.Sum32()%uint32(workers)] <- message
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+}
+
+func fire(message *sarama.ConsumerMessage) {
+	defer inflight.Done()
+	notification.Fire(fireCtx, store, message, "", notification.MessageRetry{})
+}
+
+// 等待正在发送的消息, 超过 shutdown-timeout 后取消发送
+func waitInflight() {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(*shutdown):
+		glog.Info("Cancelling in-flight notifications...")
+		cancelFire()
+		<-done
+	}
+	cancelFire()
+}
+
 func getPartitions(c sarama.Consumer) ([]int32, error) {
 	if *partitions == "all" {
 		return c.Partitions(*topic)
@@ -0,0 +1,281 @@
+package notification
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryStore 的内存实现, 只在本进程内有效, 用于测试和本地调试
+// key 的过期时间按 timeNow 计算, 访问时删除已过期的 key
+type MemoryStore struct {
+	mu      sync.Mutex
+	hashes  map[string]map[string]string
+	lists   map[string][]string
+	zsets   map[string]map[string]int64
+	expires map[string]time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		hashes:  map[string]map[string]string{},
+		lists:   map[string][]string{},
+		zsets:   map[string]map[string]int64{},
+		expires: map[string]time.Time{},
+	}
+}
+
+// 删除已过期的 key, 调用时需持有锁
+func (s *MemoryStore) expire(key string) {
+	at, ok := s.expires[key]
+	if !ok || timeNow().Before(at) {
+		return
+	}
+	s.delete(key)
+}
+
+func (s *MemoryStore) delete(key string) {
+	delete(s.hashes, key)
+	delete(s.lists, key)
+	delete(s.zsets, key)
+	delete(s.expires, key)
+}
+
+func (s *MemoryStore) SetFields(key string, fields map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	hash, ok := s.hashes[key]
+	if !ok {
+		hash = map[string]string{}
+		s.hashes[key] = hash
+	}
+	for field, value := range fields {
+		hash[field] = fmt.Sprint(value)
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetField(key string, field string) (value string, found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	value, found = s.hashes[key][field]
+	return
+}
+
+func (s *MemoryStore) GetFields(key string) (fields map[string]string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	fields = make(map[string]string, len(s.hashes[key]))
+	for field, value := range s.hashes[key] {
+		fields[field] = value
+	}
+	return
+}
+
+func (s *MemoryStore) DeleteFields(key string, fields ...string) (n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	hash := s.hashes[key]
+	for _, field := range fields {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			n++
+		}
+	}
+	if hash != nil && len(hash) == 0 {
+		s.delete(key)
+	}
+	return
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(key)
+	return nil
+}
+
+func (s *MemoryStore) ExpireAt(key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	_, isHash := s.hashes[key]
+	_, isList := s.lists[key]
+	_, isZset := s.zsets[key]
+	if isHash || isList || isZset {
+		s.expires[key] = at
+		s.expire(key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Push(key string, member string) (n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	s.lists[key] = append(s.lists[key], member)
+	return int64(len(s.lists[key])), nil
+}
+
+func (s *MemoryStore) Head(key string) (member string, found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	if list := s.lists[key]; len(list) > 0 {
+		return list[0], true, nil
+	}
+	return
+}
+
+func (s *MemoryStore) Pop(key string) (member string, found bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	list := s.lists[key]
+	if len(list) == 0 {
+		return
+	}
+	member, found = list[0], true
+	if len(list) == 1 {
+		s.delete(key)
+	} else {
+		s.lists[key] = list[1:]
+	}
+	return
+}
+
+func (s *MemoryStore) Len(key string) (n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	return int64(len(s.lists[key])), nil
+}
+
+func (s *MemoryStore) Range(key string) (members []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	return append([]string{}, s.lists[key]...), nil
+}
+
+func (s *MemoryStore) Remove(key string, member string) (n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	list := s.lists[key]
+	kept := make([]string, 0, len(list))
+	for _, m := range list {
+		if m == member {
+			n++
+			continue
+		}
+		kept = append(kept, m)
+	}
+	if len(kept) == 0 {
+		s.delete(key)
+	} else {
+		s.lists[key] = kept
+	}
+	return
+}
+
+func (s *MemoryStore) Schedule(key string, member string, at int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	zset, ok := s.zsets[key]
+	if !ok {
+		zset = map[string]int64{}
+		s.zsets[key] = zset
+	}
+	zset[member] = at
+	return nil
+}
+
+func (s *MemoryStore) Due(key string, until int64) (members []string, err error) {
+	entries, _ := s.Scheduled(key)
+	for _, entry := range entries {
+		if entry.At <= until {
+			members = append(members, entry.Member)
+		}
+	}
+	return
+}
+
+// 按发送时间排序, 时间相同时按成员排序, 与 redis 相同
+func (s *MemoryStore) Scheduled(key string) (entries []ScheduledMember, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	entries = make([]ScheduledMember, 0, len(s.zsets[key]))
+	for member, at := range s.zsets[key] {
+		entries = append(entries, ScheduledMember{Member: member, At: at})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].At != entries[j].At {
+			return entries[i].At < entries[j].At
+		}
+		return entries[i].Member < entries[j].Member
+	})
+	return
+}
+
+func (s *MemoryStore) Unschedule(key string, member string) (removed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	zset := s.zsets[key]
+	if _, removed = zset[member]; removed {
+		delete(zset, member)
+		if len(zset) == 0 {
+			s.delete(key)
+		}
+	}
+	return
+}
+
+// 与 redis 实现的 tokenBucket 脚本相同
+func (s *MemoryStore) TakeToken(key string, rate float64, burst int, nowMs int64) (waitMs int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(key)
+
+	tokens, e1 := strconv.ParseFloat(s.hashes[key]["tokens"], 64)
+	ts, e2 := strconv.ParseInt(s.hashes[key]["ts"], 10, 64)
+	if e1 != nil || e2 != nil {
+		tokens, ts = float64(burst), nowMs
+	}
+	tokens = math.Min(float64(burst), tokens+math.Max(0, float64(nowMs-ts))*rate/1000)
+	if tokens >= 1 {
+		tokens--
+	} else {
+		waitMs = int64(math.Ceil((1 - tokens) * 1000 / rate))
+	}
+	s.hashes[key] = map[string]string{
+		"tokens": strconv.FormatFloat(tokens, 'f', -1, 64),
+		"ts":     strconv.FormatInt(nowMs, 10),
+	}
+	s.expires[key] = time.Unix(0, nowMs*int64(time.Millisecond)).Add(time.Duration(math.Ceil(float64(burst)*1000/rate)+1000) * time.Millisecond)
+	return
+}
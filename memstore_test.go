@@ -0,0 +1,121 @@
+package notification
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 假时钟, 替换 timeNow
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// 使用假时钟, 测试结束时调用返回的函数恢复
+func useFakeClock(now time.Time) (clock *fakeClock, restore func()) {
+	clock = &fakeClock{now: now}
+	timeNow = clock.Now
+	return clock, func() {
+		timeNow = time.Now
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryStore(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+
+	// hash
+	assert.Nil(store.SetFields("h", map[string]interface{}{"attempts": int32(1), "subscription": ""}))
+	value, found, err := store.GetField("h", "attempts")
+	assert.Nil(err)
+	assert.True(found)
+	assert.Equal("1", value)
+	_, found, _ = store.GetField("h", "missing")
+	assert.False(found)
+	n, _ := store.DeleteFields("h", "subscription", "missing")
+	assert.Equal(int64(1), n)
+	fields, _ := store.GetFields("h")
+	assert.Equal(map[string]string{"attempts": "1"}, fields)
+
+	// list
+	store.Push("l", "a")
+	store.Push("l", "b")
+	n, _ = store.Push("l", "a")
+	assert.Equal(int64(3), n)
+	head, found, _ := store.Head("l")
+	assert.True(found)
+	assert.Equal("a", head)
+	n, _ = store.Remove("l", "a")
+	assert.Equal(int64(2), n)
+	members, _ := store.Range("l")
+	assert.Equal([]string{"b"}, members)
+	popped, found, _ := store.Pop("l")
+	assert.True(found)
+	assert.Equal("b", popped)
+	_, found, _ = store.Pop("l")
+	assert.False(found)
+
+	// sorted set, 按时间排序, 时间相同时按成员排序
+	store.Schedule("z", "c", 300)
+	store.Schedule("z", "b", 100)
+	store.Schedule("z", "a", 100)
+	members, _ = store.Due("z", 200)
+	assert.Equal([]string{"a", "b"}, members)
+	scheduled, _ := store.Scheduled("z")
+	assert.Equal([]ScheduledMember{{"a", 100}, {"b", 100}, {"c", 300}}, scheduled)
+	removed, _ := store.Unschedule("z", "b")
+	assert.True(removed)
+	removed, _ = store.Unschedule("z", "b")
+	assert.False(removed)
+
+	// 过期按假时钟计算
+	store.ExpireAt("h", clock.Now().Add(time.Hour))
+	clock.Advance(time.Hour - time.Second)
+	fields, _ = store.GetFields("h")
+	assert.Len(fields, 1)
+	clock.Advance(time.Second)
+	fields, _ = store.GetFields("h")
+	assert.Len(fields, 0)
+
+	assert.Nil(store.Delete("z"))
+	scheduled, _ = store.Scheduled("z")
+	assert.Len(scheduled, 0)
+}
+
+func TestMemoryStoreTakeToken(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	now := clock.Now().UnixNano() / int64(time.Millisecond)
+
+	// 容量为 2, 每秒 10 个
+	wait, err := store.TakeToken("r", 10, 2, now)
+	assert.Nil(err)
+	assert.Equal(int64(0), wait)
+	wait, _ = store.TakeToken("r", 10, 2, now)
+	assert.Equal(int64(0), wait)
+	wait, _ = store.TakeToken("r", 10, 2, now)
+	assert.Equal(int64(100), wait)
+	wait, _ = store.TakeToken("r", 10, 2, now+100)
+	assert.Equal(int64(0), wait)
+}
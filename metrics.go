@@ -0,0 +1,23 @@
+package notification
+
+import "expvar"
+
+// 通知结果, 用于计数 (expvar: /debug/vars 中的 notification_outcomes)
+const (
+	OUTCOME_DELIVERED       = "delivered"
+	OUTCOME_RETRY_SCHEDULED = "retry_scheduled"
+	OUTCOME_CAPPED          = "capped"
+	OUTCOME_EXPIRED         = "expired"
+	OUTCOME_INVALID         = "invalid"
+	OUTCOME_DELAYED         = "delayed"
+	OUTCOME_THROTTLED       = "throttled"
+	OUTCOME_HELD            = "held"
+	OUTCOME_UNROUTED        = "unrouted" // 没有订阅该事件的地址
+	OUTCOME_REJECTED        = "rejected" // 发送通道返回不可重试的失败, 放入死信列表
+)
+
+var outcomes = expvar.NewMap("notification_outcomes")
+
+func countOutcome(outcome string) {
+	outcomes.Add(outcome, 1)
+}
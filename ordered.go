@@ -0,0 +1,122 @@
+package notification
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+const (
+	FORMAT_KEY = "%s-list-key-%s-%d" // 顺序发送时同一 key 发往同一通知地址 (序号) 未完成的消息, 第一个为正在发送 (或重试, 延迟) 的消息, 其余为排队等待的消息
+)
+
+var (
+	orderedMu     sync.RWMutex
+	orderedTopics = map[string]bool{}
+)
+
+// 设置按 kafka 消息 key 顺序发送的 topic, 由启动程序根据配置设置
+func SetOrderedTopics(topics []string) {
+	orderedMu.Lock()
+	defer orderedMu.Unlock()
+	orderedTopics = make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		orderedTopics[topic] = true
+	}
+}
+
+func IsOrderedTopic(topic string) bool {
+	orderedMu.RLock()
+	defer orderedMu.RUnlock()
+	return orderedTopics[topic]
+}
+
+// 消息是否需要顺序发送, 没有 key 的消息不保证顺序
+func isOrdered(msg *sarama.ConsumerMessage) bool {
+	return len(msg.Key) > 0 && IsOrderedTopic(msg.Topic)
+}
+
+// 首次发送时追加到该 key 的队列, 追加前队列不为空 (同一 key 有未完成的消息) 时排队, 由前一条消息完成时释放
+// 追加并取得长度为一次操作, 多个实时处理程序同时处理同一 key 时只有一条消息占用该 key
+func holdKey(store RetryStore, msg *sarama.ConsumerMessage, retryData MessageRetry) (held bool, err error) {
+	keyList := fmt.Sprintf(FORMAT_KEY, msg.Topic, msg.Key, retryData.Destination)
+
+	// 先写重试数据再追加到队列, 释放排队的消息时需要读取重试数据
+	if err = saveRetryData(store, retryData.HashKey(msg.Topic), retryData.Fields(), timeNow().AddDate(0, 0, 7)); err != nil {
+		return
+	}
+	var n int64
+	if n, err = pushKey(store, keyList, retryData.Member()); err != nil {
+		return
+	}
+	held = n > 1
+	return
+}
+
+// 消息处理结束后更新该 key 的队列
+// parked 表示消息未完成 (进入重试, 延迟队列), 继续占用该 key, 之后同一 key 的消息排队
+// 否则消息已完成 (成功, 达到上限, 过期或无效), 释放该 key 给下一条排队的消息, 由重试程序从延迟队列中取出发送
+func settleKey(store RetryStore, msg *sarama.ConsumerMessage, retryData MessageRetry, parked bool) {
+	fn := "settleKey"
+
+	if parked {
+		return
+	}
+
+	keyList := fmt.Sprintf(FORMAT_KEY, msg.Topic, msg.Key, retryData.Destination)
+
+	head, found, err := store.Head(keyList)
+	if err != nil {
+		glog.Errorf("@%s, store.Head failed, err=%s, key=%s", fn, err, keyList)
+		return
+	}
+	if !found || head != retryData.Member() {
+		return
+	}
+	if _, _, err = store.Pop(keyList); err != nil {
+		glog.Errorf("@%s, store.Pop failed, err=%s, key=%s", fn, err, keyList)
+		return
+	}
+
+	member, found, err := store.Head(keyList)
+	if err != nil {
+		glog.Errorf("@%s, store.Head failed, err=%s, key=%s", fn, err, keyList)
+		return
+	}
+	if !found {
+		return
+	}
+	_, hashKey, err := ParseMember(msg.Topic, member)
+	if err != nil {
+		glog.Errorf("@%s, ParseMember failed, err=%s, member=%s", fn, err, member)
+		return
+	}
+	// next_time 不为 0, 重试程序取出后不会再次排队; attempts 为 0, 未到 deliver_at 时仍放入延迟队列
+	now := timeNow().Unix()
+	zsetKey := fmt.Sprintf(FORMAT_DELAY, msg.Topic)
+	if err = store.SetFields(hashKey, map[string]interface{}{"next_time": now}); err != nil {
+		glog.Errorf("@%s, store.SetFields failed, err=%s, key=%s", fn, err, hashKey)
+		return
+	}
+	if err = store.Schedule(zsetKey, member, now); err != nil {
+		glog.Errorf("@%s, store.Schedule failed, err=%s, key=%s, member=%s", fn, err, zsetKey, member)
+		return
+	}
+	glog.Infof("@%s, released, topic=%s, key=%s, member=%s", fn, msg.Topic, msg.Key, member)
+}
+
+// 追加到队列, 返回追加后的长度, 设置过期时间失败时只记录日志
+func pushKey(store RetryStore, keyList string, member string) (n int64, err error) {
+	fn := "pushKey"
+
+	if n, err = store.Push(keyList, member); err != nil {
+		glog.Errorf("@%s, store.Push failed, err=%s, key=%s, member=%s", fn, err, keyList, member)
+		return
+	}
+	if e := store.ExpireAt(keyList, timeNow().AddDate(0, 0, 7)); e != nil {
+		glog.Errorf("@%s, store.ExpireAt failed, err=%s, key=%s, time=%s", fn, e, keyList, timeNow().AddDate(0, 0, 7))
+	}
+	return
+}
@@ -0,0 +1,185 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用顺序发送的 topic, 测试结束时恢复
+func useOrderedTopics(t *testing.T, topics ...string) {
+	SetOrderedTopics(topics)
+	t.Cleanup(func() {
+		SetOrderedTopics(nil)
+	})
+}
+
+func orderedMessage(offset int64, content string, meta MessageMeta) *sarama.ConsumerMessage {
+	msg := testMessage(offset, content, meta)
+	msg.Key = []byte("order-1")
+	return msg
+}
+
+func TestHoldKey(t *testing.T) {
+	assert := assert.New(t)
+
+	_, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	keyList := "mytopic-list-key-order-1-0"
+
+	// 第一条消息占用该 key, 之后的消息按顺序排队
+	held, err := holdKey(store, orderedMessage(10, "", MessageMeta{}), MessageRetry{Offset: 10, Partition: 1})
+	assert.Nil(err)
+	assert.False(held)
+	held, err = holdKey(store, orderedMessage(11, "", MessageMeta{}), MessageRetry{Offset: 11, Partition: 1})
+	assert.Nil(err)
+	assert.True(held)
+	held, err = holdKey(store, orderedMessage(12, "", MessageMeta{}), MessageRetry{Offset: 12, Partition: 1})
+	assert.Nil(err)
+	assert.True(held)
+	members, _ := store.Range(keyList)
+	assert.Equal([]string{"1:10:0", "1:11:0", "1:12:0"}, members)
+
+	// 不同的通知地址互不影响
+	held, err = holdKey(store, orderedMessage(10, "", MessageMeta{}), MessageRetry{Offset: 10, Partition: 1, Destination: 1})
+	assert.Nil(err)
+	assert.False(held)
+
+	// 未完成时继续占用
+	settleKey(store, orderedMessage(10, "", MessageMeta{}), MessageRetry{Offset: 10, Partition: 1}, true)
+	members, _ = store.Range(keyList)
+	assert.Len(members, 3)
+
+	// 不是第一条的消息不释放
+	settleKey(store, orderedMessage(11, "", MessageMeta{}), MessageRetry{Offset: 11, Partition: 1}, false)
+	members, _ = store.Range(keyList)
+	assert.Len(members, 3)
+
+	// 完成后释放下一条, 放入延迟队列立即发送
+	settleKey(store, orderedMessage(10, "", MessageMeta{}), MessageRetry{Offset: 10, Partition: 1}, false)
+	members, _ = store.Range(keyList)
+	assert.Equal([]string{"1:11:0", "1:12:0"}, members)
+	scheduled, _ := store.Scheduled("mytopic-zset-delayed")
+	assert.Equal([]ScheduledMember{{"1:11:0", 1500000000}}, scheduled)
+}
+
+func TestOrdered(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	useOrderedTopics(t, "mytopic")
+	store := NewMemoryStore()
+	source := NewMemorySource()
+	retrier := NewRetrier("mytopic", store, source)
+	poll := func() {
+		retrier.Poll(context.Background(), nil)
+		retrier.Wait()
+	}
+
+	// 第一次请求失败, 之后成功, 记录收到的请求体
+	var (
+		mu     sync.Mutex
+		bodies []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		n := len(bodies)
+		mu.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("success"))
+	}))
+	defer server.Close()
+	received := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, bodies...)
+	}
+
+	// 第一条发送失败进入重试, 之后同一 key 的消息排队, 第三条要求 1 小时后发送
+	for i, meta := range []MessageMeta{{Url: server.URL}, {Url: server.URL}, {Url: server.URL, Delay: 3600}} {
+		msg := orderedMessage(int64(10+i), fmt.Sprintf(`{"id":%d}`, i+1), meta)
+		source.Add(msg)
+		assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	}
+	assert.Equal([]string{`{"id":1}`}, received())
+	members, _ := store.Range("mytopic-list-key-order-1-0")
+	assert.Equal([]string{"1:10:0", "1:11:0", "1:12:0"}, members)
+
+	// 第一条重试成功后释放第二条, 第二条成功后释放第三条
+	clock.Advance(4 * time.Minute)
+	poll()
+	poll()
+	assert.Equal([]string{`{"id":1}`, `{"id":1}`, `{"id":2}`}, received())
+
+	// 释放的第三条未到发送时间, 放入延迟队列并继续占用该 key
+	poll()
+	assert.Len(received(), 3)
+	scheduled, _ := store.Scheduled("mytopic-zset-delayed")
+	assert.Equal([]ScheduledMember{{"1:12:0", 1500000000 + 3600}}, scheduled)
+	members, _ = store.Range("mytopic-list-key-order-1-0")
+	assert.Equal([]string{"1:12:0"}, members)
+
+	clock.Advance(time.Hour)
+	poll()
+	assert.Equal([]string{`{"id":1}`, `{"id":1}`, `{"id":2}`, `{"id":3}`}, received())
+	members, _ = store.Range("mytopic-list-key-order-1-0")
+	assert.Len(members, 0)
+}
+
+func TestOrderedSubscriptionDeleted(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	useOrderedTopics(t, "mytopic")
+	store := NewMemoryStore()
+	source := NewMemorySource()
+	retrier := NewRetrier("mytopic", store, source)
+	server, requests := testReceiver(http.StatusInternalServerError)
+	defer server.Close()
+
+	sub, err := SaveSubscription(store, Subscription{Event: "order.paid", Destination: Destination{Url: server.URL}})
+	assert.Nil(err)
+	for i := 0; i < 2; i++ {
+		msg := orderedMessage(int64(10+i), fmt.Sprintf(`{"id":%d}`, i+1), MessageMeta{Event: "order.paid"})
+		source.Add(msg)
+		assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	}
+	requestsBefore := atomic.LoadInt32(requests)
+	members, _ := store.Range("mytopic-list-key-order-1-0")
+	assert.Equal([]string{"1:10:0", "1:11:0"}, members)
+
+	// 重试时订阅已删除, 第一条不再发送并释放该 key, 第二条同样找不到订阅后完成
+	deleted, err := DeleteSubscription(store, sub.Id)
+	assert.Nil(err)
+	assert.True(deleted)
+	clock.Advance(4 * time.Minute)
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	members, _ = store.Range("mytopic-list-key-order-1-0")
+	assert.Equal([]string{"1:11:0"}, members)
+	scheduled, _ := store.Scheduled("mytopic-zset-delayed")
+	assert.Equal([]ScheduledMember{{"1:11:0", clock.Now().Unix()}}, scheduled)
+
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	members, _ = store.Range("mytopic-list-key-order-1-0")
+	assert.Len(members, 0)
+	assert.Equal(requestsBefore, atomic.LoadInt32(requests))
+}
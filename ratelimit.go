@@ -0,0 +1,111 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	FORMAT_RATE = "notification-rate-%s" // 限流令牌桶 (redis hash), 所有 topic 和进程共享
+)
+
+// 限流规则
+// Prefix 为 host (api.example.com) 时限制该 host 的所有请求, 为 URL 前缀 (https://api.example.com/notify) 时只限制该前缀
+type RateLimit struct {
+	Prefix string
+	Rate   float64 // 每秒请求数
+	Burst  int     // 令牌桶容量, 即允许的突发请求数
+}
+
+type rateLimiter struct {
+	mu      sync.RWMutex
+	limits  []RateLimit
+	maxWait time.Duration
+}
+
+var limiter = &rateLimiter{}
+
+// 设置限流规则, 超过限流时最多等待 maxWait, 超过则放入延迟队列, 由启动程序根据配置设置
+func SetRateLimits(limits []RateLimit, maxWait time.Duration) {
+	sorted := make([]RateLimit, 0, len(limits))
+	for _, limit := range limits {
+		if limit.Prefix != "" && limit.Rate > 0 {
+			if limit.Burst < 1 {
+				limit.Burst = 1
+			}
+			sorted = append(sorted, limit)
+		}
+	}
+	// 最长的前缀优先匹配
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.limits = sorted
+	limiter.maxWait = maxWait
+}
+
+// 匹配 url 的限流规则
+func (l *rateLimiter) match(url string) (limit RateLimit, ok bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, limit = range l.limits {
+		if matchPrefix(limit.Prefix, url) {
+			return limit, true
+		}
+	}
+	return RateLimit{}, false
+}
+
+// prefix 为 host 时匹配该 host 的所有地址, 为 URL 前缀 (包含 ://) 时只匹配该前缀
+func matchPrefix(prefix string, url string) bool {
+	if strings.Contains(prefix, "://") {
+		return strings.HasPrefix(url, prefix)
+	}
+	return prefix == urlHost(url)
+}
+
+// 取得发送 url 的令牌, 必要时等待
+// wait > 0 表示等待 maxWait 后仍未取得令牌, 调用方应在 wait 之后再发送
+// 等待时 ctx 取消 (如程序退出) 返回 ctx.Err(); 存储出错时不限流
+func (l *rateLimiter) take(ctx context.Context, store RetryStore, url string) (wait time.Duration, err error) {
+	fn := "rateLimiter.take"
+
+	limit, ok := l.match(url)
+	if !ok {
+		return 0, nil
+	}
+	l.mu.RLock()
+	maxWait := l.maxWait
+	l.mu.RUnlock()
+
+	key := fmt.Sprintf(FORMAT_RATE, limit.Prefix)
+	var waited time.Duration
+	for {
+		ms, e := store.TakeToken(key, limit.Rate, limit.Burst, timeNow().UnixNano()/int64(time.Millisecond))
+		if e != nil {
+			glog.Errorf("@%s, store.TakeToken failed, err=%s, key=%s", fn, e, key)
+			return 0, nil
+		}
+		if ms <= 0 {
+			return 0, nil
+		}
+		wait = time.Duration(ms) * time.Millisecond
+		if waited+wait > maxWait {
+			return wait, nil
+		}
+		if !sleepContext(ctx, wait) {
+			return 0, ctx.Err()
+		}
+		waited += wait
+	}
+}
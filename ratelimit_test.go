@@ -0,0 +1,169 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// 使用 miniredis 的 RetryStore, 执行令牌桶的 lua 脚本
+// 使用假时钟时需要 SetTime, 否则 ExpireAt 按 miniredis 的时间立即过期
+func newMiniredisStore(t *testing.T) (RetryStore, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("miniredis.Run failed, err=%s", err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+		mr.Close()
+	})
+	return NewRedisStore(client), mr
+}
+
+func TestRateLimitMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	defer SetRateLimits(nil, 0)
+	SetRateLimits([]RateLimit{
+		{Prefix: "api.example.com", Rate: 10, Burst: 10},
+		{Prefix: "https://api.example.com/notify", Rate: 2},
+		{Prefix: "disabled.example.com", Rate: 0},
+	}, 0)
+
+	limit, ok := limiter.match("https://api.example.com/notify/order")
+	assert.True(ok)
+	assert.Equal("https://api.example.com/notify", limit.Prefix)
+	assert.Equal(1, limit.Burst)
+
+	limit, ok = limiter.match("https://api.example.com/other")
+	assert.True(ok)
+	assert.Equal("api.example.com", limit.Prefix)
+
+	_, ok = limiter.match("https://other.example.com/notify")
+	assert.False(ok)
+
+	_, ok = limiter.match("https://disabled.example.com/notify")
+	assert.False(ok)
+}
+
+func TestTokenBucket(t *testing.T) {
+	miniredisStore, _ := newMiniredisStore(t)
+	for name, store := range map[string]RetryStore{"redis": miniredisStore, "memory": NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			_, restore := useFakeClock(time.Unix(1500000000, 0))
+			defer restore()
+			now := int64(1500000000000)
+
+			// 容量为 3, 每秒 2 个: 突发 3 个后需要等待
+			for i := 0; i < 3; i++ {
+				wait, err := store.TakeToken("r", 2, 3, now)
+				assert.Nil(err)
+				assert.Equal(int64(0), wait)
+			}
+			wait, err := store.TakeToken("r", 2, 3, now)
+			assert.Nil(err)
+			assert.Equal(int64(500), wait)
+
+			// 等待时不消耗令牌, 按时间补充
+			wait, _ = store.TakeToken("r", 2, 3, now+200)
+			assert.Equal(int64(300), wait)
+			wait, _ = store.TakeToken("r", 2, 3, now+500)
+			assert.Equal(int64(0), wait)
+			wait, _ = store.TakeToken("r", 2, 3, now+500)
+			assert.Equal(int64(500), wait)
+
+			// 补充不超过容量
+			for i := 0; i < 3; i++ {
+				wait, _ = store.TakeToken("r", 2, 3, now+60000)
+				assert.Equal(int64(0), wait)
+			}
+			wait, _ = store.TakeToken("r", 2, 3, now+60000)
+			assert.Equal(int64(500), wait)
+
+			// 不同的 key 独立计数
+			wait, _ = store.TakeToken("other", 2, 3, now+60000)
+			assert.Equal(int64(0), wait)
+		})
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	assert := assert.New(t)
+
+	defer SetRateLimits(nil, 0)
+	store, _ := newMiniredisStore(t)
+	take := func(ctx context.Context, url string) time.Duration {
+		wait, err := limiter.take(ctx, store, url)
+		assert.Nil(err)
+		return wait
+	}
+
+	// 每秒 50 个, 等待不超过 maxWait 时等待后发送
+	SetRateLimits([]RateLimit{{Prefix: "a.example.com", Rate: 50, Burst: 1}}, time.Second)
+	assert.Equal(time.Duration(0), take(context.Background(), "http://a.example.com/notify"))
+	started := time.Now()
+	assert.Equal(time.Duration(0), take(context.Background(), "http://a.example.com/notify"))
+	assert.True(time.Since(started) >= 10*time.Millisecond)
+
+	// 需要等待的时间超过 maxWait 时返回等待时间
+	SetRateLimits([]RateLimit{{Prefix: "a.example.com", Rate: 50, Burst: 1}}, 0)
+	wait := take(context.Background(), "http://a.example.com/notify")
+	assert.True(wait > 0 && wait <= 20*time.Millisecond)
+
+	// 没有匹配的规则时不限流
+	assert.Equal(time.Duration(0), take(context.Background(), "http://b.example.com/notify"))
+
+	// 等待时 ctx 取消则立即返回
+	SetRateLimits([]RateLimit{{Prefix: "c.example.com", Rate: 1, Burst: 1}}, time.Minute)
+	assert.Equal(time.Duration(0), take(context.Background(), "http://c.example.com/notify"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	started = time.Now()
+	wait, err := limiter.take(ctx, store, "http://c.example.com/notify")
+	assert.Equal(context.DeadlineExceeded, err)
+	assert.Equal(time.Duration(0), wait)
+	assert.True(time.Since(started) < 500*time.Millisecond)
+}
+
+func TestThrottle(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	defer SetRateLimits(nil, 0)
+	store, mr := newMiniredisStore(t)
+	mr.SetTime(clock.Now())
+	source := NewMemorySource()
+	retrier := NewRetrier("mytopic", store, source)
+	server, requests := testReceiver(http.StatusOK)
+	defer server.Close()
+	SetRateLimits([]RateLimit{{Prefix: urlHost(server.URL), Rate: 1, Burst: 1}}, 0)
+
+	// 第二条超过限流, 放入延迟队列
+	for i := 0; i < 2; i++ {
+		msg := testMessage(int64(10+i), `{"id":1}`, MessageMeta{Url: server.URL})
+		source.Add(msg)
+		assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(requests))
+	scheduled, _ := store.Scheduled("mytopic-zset-delayed")
+	assert.Equal([]ScheduledMember{{"1:11:0", clock.Now().Unix() + 2}}, scheduled)
+
+	// 到时间后由重试程序发送
+	clock.Advance(2 * time.Second)
+	mr.SetTime(clock.Now())
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(2), atomic.LoadInt32(requests))
+	scheduled, _ = store.Scheduled("mytopic-zset-delayed")
+	assert.Empty(scheduled)
+}
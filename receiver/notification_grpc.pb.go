@@ -0,0 +1,133 @@
+// gRPC 通知接收服务, 通知程序通过 NotificationReceiver.Deliver 发送通知
+// 修改后在 receiver 目录下重新生成:
+// protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative notification.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: notification.proto
+
+package receiver
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	NotificationReceiver_Deliver_FullMethodName = "/notification.NotificationReceiver/Deliver"
+)
+
+// NotificationReceiverClient is the client API for NotificationReceiver service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// 接收方返回 OK 表示成功
+// Unavailable, DeadlineExceeded, ResourceExhausted, Aborted, Internal, Unknown 等表示稍后重试
+// InvalidArgument, NotFound, PermissionDenied 等表示重试也不会成功; AlreadyExists 表示已收到过, 视为成功
+type NotificationReceiverClient interface {
+	Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (*DeliverResponse, error)
+}
+
+type notificationReceiverClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNotificationReceiverClient(cc grpc.ClientConnInterface) NotificationReceiverClient {
+	return &notificationReceiverClient{cc}
+}
+
+func (c *notificationReceiverClient) Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (*DeliverResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeliverResponse)
+	err := c.cc.Invoke(ctx, NotificationReceiver_Deliver_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NotificationReceiverServer is the server API for NotificationReceiver service.
+// All implementations must embed UnimplementedNotificationReceiverServer
+// for forward compatibility.
+//
+// 接收方返回 OK 表示成功
+// Unavailable, DeadlineExceeded, ResourceExhausted, Aborted, Internal, Unknown 等表示稍后重试
+// InvalidArgument, NotFound, PermissionDenied 等表示重试也不会成功; AlreadyExists 表示已收到过, 视为成功
+type NotificationReceiverServer interface {
+	Deliver(context.Context, *DeliverRequest) (*DeliverResponse, error)
+	mustEmbedUnimplementedNotificationReceiverServer()
+}
+
+// UnimplementedNotificationReceiverServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedNotificationReceiverServer struct{}
+
+func (UnimplementedNotificationReceiverServer) Deliver(context.Context, *DeliverRequest) (*DeliverResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Deliver not implemented")
+}
+func (UnimplementedNotificationReceiverServer) mustEmbedUnimplementedNotificationReceiverServer() {}
+func (UnimplementedNotificationReceiverServer) testEmbeddedByValue()                              {}
+
+// UnsafeNotificationReceiverServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NotificationReceiverServer will
+// result in compilation errors.
+type UnsafeNotificationReceiverServer interface {
+	mustEmbedUnimplementedNotificationReceiverServer()
+}
+
+func RegisterNotificationReceiverServer(s grpc.ServiceRegistrar, srv NotificationReceiverServer) {
+	// If the following call panics, it indicates UnimplementedNotificationReceiverServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&NotificationReceiver_ServiceDesc, srv)
+}
+
+func _NotificationReceiver_Deliver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeliverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NotificationReceiverServer).Deliver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: NotificationReceiver_Deliver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NotificationReceiverServer).Deliver(ctx, req.(*DeliverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NotificationReceiver_ServiceDesc is the grpc.ServiceDesc for NotificationReceiver service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var NotificationReceiver_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "notification.NotificationReceiver",
+	HandlerType: (*NotificationReceiverServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Deliver",
+			Handler:    _NotificationReceiver_Deliver_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "notification.proto",
+}
@@ -0,0 +1,45 @@
+// gRPC 通知接收服务
+// 通知程序通过 NotificationReceiver.Deliver 发送通知, 接收方按 notification.proto 生成代码, 或使用本包生成的代码:
+// 实现 NotificationReceiverServer 并用 RegisterNotificationReceiverServer 注册
+package receiver
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 参考实现: 记录收到的通知, 用于测试
+// Fail 不为空时按顺序返回其中的错误码 (codes.OK 表示成功), 用完后都返回成功
+type Recorder struct {
+	UnimplementedNotificationReceiverServer
+
+	mu       sync.Mutex
+	Requests []*DeliverRequest
+	Fail     []codes.Code
+}
+
+func (r *Recorder) Deliver(ctx context.Context, req *DeliverRequest) (*DeliverResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Requests = append(r.Requests, proto.Clone(req).(*DeliverRequest))
+	if len(r.Fail) > 0 {
+		code := r.Fail[0]
+		r.Fail = r.Fail[1:]
+		if code != codes.OK {
+			return nil, status.Error(code, code.String())
+		}
+	}
+	return &DeliverResponse{Message: "ok"}, nil
+}
+
+// 已收到的通知
+func (r *Recorder) Received() []*DeliverRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*DeliverRequest(nil), r.Requests...)
+}
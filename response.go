@@ -0,0 +1,77 @@
+package notification
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	DEFAULT_MAX_RESPONSE_BYTES = 1 << 20 // 默认最多读取 1MB 返回内容
+	DEFAULT_AUDIT_BYTES        = 2048    // 默认在日志和状态事件中保留 2KB 返回内容
+)
+
+// 返回内容的读取和保留
+type ResponseConfig struct {
+	MaxBytes   int64 // 最多读取的返回内容字节数 (解压后), 其余丢弃, 检查返回时只使用读取的部分
+	AuditBytes int   // 日志和状态事件中保留的返回内容字节数
+}
+
+var responseConfig = struct {
+	sync.RWMutex
+	config ResponseConfig
+}{config: ResponseConfig{MaxBytes: DEFAULT_MAX_RESPONSE_BYTES, AuditBytes: DEFAULT_AUDIT_BYTES}}
+
+// 设置返回内容的限制, 0 表示使用默认值, 由启动程序根据配置设置
+func SetResponseConfig(config ResponseConfig) {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DEFAULT_MAX_RESPONSE_BYTES
+	}
+	if config.AuditBytes <= 0 {
+		config.AuditBytes = DEFAULT_AUDIT_BYTES
+	}
+	responseConfig.Lock()
+	defer responseConfig.Unlock()
+	responseConfig.config = config
+}
+
+func getResponseConfig() ResponseConfig {
+	responseConfig.RLock()
+	defer responseConfig.RUnlock()
+	return responseConfig.config
+}
+
+// 读取不超过 MaxBytes 的返回内容, truncated 表示超过部分已丢弃
+// 接收方返回 gzip 而 Transport 没有自动解压时 (如自定义了 Accept-Encoding) 解压后再读取
+func readResponse(res *http.Response) (body string, truncated bool, err error) {
+	maxBytes := getResponseConfig().MaxBytes
+
+	var reader io.Reader = res.Body
+	if !res.Uncompressed && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(res.Body); err != nil {
+			return
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if int64(len(b)) > maxBytes {
+		b, truncated = b[:maxBytes], true
+	}
+	return string(b), truncated, err
+}
+
+// 日志和状态事件中保留的返回内容, 超过 AuditBytes 时截断并注明原长度
+func auditCopy(result string) string {
+	auditBytes := getResponseConfig().AuditBytes
+	if len(result) <= auditBytes {
+		return result
+	}
+	return strings.ToValidUTF8(result[:auditBytes], "") + fmt.Sprintf("...(truncated, %d bytes)", len(result))
+}
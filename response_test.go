@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	SetResponseConfig(ResponseConfig{MaxBytes: 16, AuditBytes: 8})
+	defer SetResponseConfig(ResponseConfig{})
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(`{"code":"0000"}`))
+	gz.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Write([]byte(strings.Repeat("a", 1<<20)))
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipped.Bytes())
+		default:
+			w.Write([]byte("success"))
+		}
+	}))
+	defer server.Close()
+
+	// 超过 MaxBytes 的部分丢弃
+	result, statusCode, err := post(context.Background(), `{}`, server.URL+"/large", "", "", "", Timeouts{})
+	assert.Nil(err)
+	assert.Equal(200, statusCode)
+	assert.Equal(strings.Repeat("a", 16), result)
+
+	// 自定义 Accept-Encoding 时 Transport 不会自动解压
+	result, _, err = post(context.Background(), `{}`, server.URL+"/gzip", `{"Accept-Encoding":"gzip"}`, "", "", Timeouts{})
+	assert.Nil(err)
+	assert.Equal(`{"code":"0000"}`, result)
+
+	// 检查返回使用读取的内容, 日志和状态事件使用截断的内容
+	outcome := httpChannel{}.Send(context.Background(), Delivery{Content: `{}`, Destination: Destination{Url: server.URL + "/gzip", Headers: `{"Accept-Encoding":"gzip"}`}})
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.Equal(`{"code":...(truncated, 15 bytes)`, outcome.Result)
+	outcome = httpChannel{}.Send(context.Background(), Delivery{Content: `{}`, Destination: Destination{Url: server.URL}})
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.Equal("success", outcome.Result)
+}
+
+func TestAuditCopy(t *testing.T) {
+	assert := assert.New(t)
+
+	SetResponseConfig(ResponseConfig{AuditBytes: 4})
+	defer SetResponseConfig(ResponseConfig{})
+	assert.Equal(int64(DEFAULT_MAX_RESPONSE_BYTES), getResponseConfig().MaxBytes)
+
+	assert.Equal("ok", auditCopy("ok"))
+	assert.Equal("abcd...(truncated, 6 bytes)", auditCopy("abcdef"))
+	// 不截断在多字节字符中间
+	assert.Equal("成...(truncated, 6 bytes)", auditCopy("成功"))
+}
@@ -0,0 +1,195 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// 重试程序的主循环: 从延迟队列和各级重试列表中取出已到时间的消息, 读取原消息后重新发送
+// @link https://github.com/YunzhanghuOpen/notification/issues/4
+type Retrier struct {
+	topic    string
+	store    RetryStore
+	source   MessageSource
+	inflight sync.WaitGroup
+}
+
+func NewRetrier(topic string, store RetryStore, source MessageSource) *Retrier {
+	return &Retrier{topic: topic, store: store, source: source}
+}
+
+// 取出一轮已到时间的消息, 每条消息在新的协程中发送, closing 关闭后不再取出
+// ctx 传给 Fire, 取消时正在发送的消息放入重试列表
+func (r *Retrier) Poll(ctx context.Context, closing <-chan struct{}) {
+	fn := "Retrier.Poll"
+
+	r.fireDelayed(ctx, closing)
+
+	lists := RetryLists(r.topic)
+	listsLastIndex := len(lists) - 1
+	for i, listKey := range lists {
+		glog.V(10).Infof("@%s, list=%s", fn, listKey)
+		for {
+			if isClosed(closing) {
+				return
+			}
+			member, found, err := r.store.Head(listKey)
+			if err != nil {
+				glog.Errorf("@%s, store.Head failed, err=%s, key=%s", fn, err, listKey)
+				break
+			}
+			if !found {
+				glog.V(10).Infof("@%s, list is empty, list=%s", fn, listKey)
+				break
+			}
+
+			retryData, hashKey, err := ParseMember(r.topic, member)
+			if err != nil {
+				glog.Errorf("@%s, ParseMember failed, err=%s, member=%s", fn, err, member)
+				break
+			}
+			if retryData.Attempts, retryData.NextTime, retryData.Partition, retryData.Subscription, err = loadRetryData(r.store, hashKey); err != nil {
+				glog.Errorf("@%s, loadRetryData failed, err=%s, hashKey=%s", fn, err, hashKey)
+				break
+			}
+
+			if retryData.NextTime > timeNow().Unix() {
+				break
+			}
+
+			var dest string
+			if i < listsLastIndex {
+				dest = lists[i+1]
+			} else {
+				dest = ""
+			}
+
+			r.inflight.Add(1)
+			go r.retry(ctx, dest, retryData)
+
+			if popped, _, err := r.store.Pop(listKey); err != nil {
+				glog.Errorf("@%s, store.Pop failed, err=%s, key=%s", fn, err, listKey)
+				break
+			} else if popped != member {
+				glog.Errorf("@%s, popped != member, key=%s, popped=%s, member=%s", fn, listKey, popped, member)
+				break
+			}
+		}
+	}
+}
+
+// 等待正在发送的消息
+func (r *Retrier) Wait() {
+	r.inflight.Wait()
+}
+
+// 发送延迟队列中已到时间的消息
+func (r *Retrier) fireDelayed(ctx context.Context, closing <-chan struct{}) {
+	fn := "Retrier.fireDelayed"
+
+	zsetKey := fmt.Sprintf(FORMAT_DELAY, r.topic)
+	members, err := r.store.Due(zsetKey, timeNow().Unix())
+	if err != nil {
+		glog.Errorf("@%s, store.Due failed, err=%s, key=%s", fn, err, zsetKey)
+		return
+	}
+
+	for _, member := range members {
+		if isClosed(closing) {
+			return
+		}
+		// 移除成功才发送, 避免多个重试程序重复发送
+		if removed, err := r.store.Unschedule(zsetKey, member); err != nil {
+			glog.Errorf("@%s, store.Unschedule failed, err=%s, key=%s, member=%s", fn, err, zsetKey, member)
+			continue
+		} else if !removed {
+			continue
+		}
+
+		retryData, hashKey, err := ParseMember(r.topic, member)
+		if err != nil {
+			glog.Errorf("@%s, ParseMember failed, err=%s, member=%s", fn, err, member)
+			continue
+		}
+		if retryData.Attempts, retryData.NextTime, retryData.Partition, retryData.Subscription, err = loadRetryData(r.store, hashKey); err != nil {
+			glog.Errorf("@%s, loadRetryData failed, err=%s, hashKey=%s", fn, err, hashKey)
+			continue
+		}
+
+		r.inflight.Add(1)
+		go r.retry(ctx, NextRetryList(r.topic, retryData.Attempts), retryData)
+	}
+}
+
+func (r *Retrier) retry(ctx context.Context, dest string, retryData MessageRetry) (err error) {
+	fn := "Retrier.retry"
+	defer r.inflight.Done()
+
+	message, err := r.source.Message(ctx, r.topic, retryData.Partition, retryData.Offset)
+	if err != nil {
+		glog.Errorf("@%s, source.Message failed, err=%s, topic=%s, partition=%d, offset=%d", fn, err, r.topic, retryData.Partition, retryData.Offset)
+		return
+	}
+
+	if err = Fire(ctx, r.store, message, dest, retryData); err != nil {
+		glog.Errorf("@%s, Fire failed, err=%s, topic=%s, partition=%d, offset=%d, dest=%s", fn, err, message.Topic, message.Partition, message.Offset, dest)
+		return
+	}
+
+	return
+}
+
+// subscription 为订阅 id, 旧数据和不是按事件发送的通知没有该字段
+func loadRetryData(store RetryStore, hashKey string) (attempts int32, nextTime int64, partition int32, subscription string, err error) {
+	fn := "loadRetryData"
+
+	var fields map[string]string
+	if fields, err = store.GetFields(hashKey); err != nil {
+		glog.Errorf("@%s, store.GetFields failed, err=%s, key=%s", fn, err, hashKey)
+		return
+	}
+	for _, field := range []string{"attempts", "next_time", "partition"} {
+		if _, ok := fields[field]; !ok {
+			err = errors.New("retry data not found")
+			glog.Errorf("@%s, field not found, field=%s, key=%s", fn, field, hashKey)
+			return
+		}
+	}
+	subscription = fields["subscription"]
+
+	var tmp int64
+	if tmp, err = strconv.ParseInt(fields["attempts"], 10, 32); err != nil {
+		glog.Errorf("@%s, strconv.ParseInt failed, err=%s, s=%s", fn, err, fields["attempts"])
+		return
+	} else {
+		attempts = int32(tmp)
+	}
+	if tmp, err = strconv.ParseInt(fields["next_time"], 10, 64); err != nil {
+		glog.Errorf("@%s, strconv.ParseInt failed, err=%s, s=%s", fn, err, fields["next_time"])
+		return
+	} else {
+		nextTime = tmp
+	}
+	if tmp, err = strconv.ParseInt(fields["partition"], 10, 32); err != nil {
+		glog.Errorf("@%s, strconv.ParseInt failed, err=%s, s=%s", fn, err, fields["partition"])
+		return
+	} else {
+		partition = int32(tmp)
+	}
+
+	return
+}
+
+func isClosed(closing <-chan struct{}) bool {
+	select {
+	case <-closing:
+		return true
+	default:
+		return false
+	}
+}
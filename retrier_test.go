@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetrier(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	source := NewMemorySource()
+	retrier := NewRetrier("mytopic", store, source)
+	lists := RetryLists("mytopic")
+
+	// 首次发送和第一次重试失败, 第二次重试成功
+	server, requests := testReceiver(http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK)
+	defer server.Close()
+	msg := testMessage(10, `{"id":1}`, MessageMeta{Url: server.URL})
+	source.Add(msg)
+	assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	assert.Equal(int32(1), atomic.LoadInt32(requests))
+
+	// 未到重试时间
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(requests))
+
+	clock.Advance(4 * time.Minute)
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(2), atomic.LoadInt32(requests))
+	members, _ := store.Range(lists[0])
+	assert.Len(members, 0)
+	members, _ = store.Range(lists[1])
+	assert.Equal([]string{"1:10:0"}, members)
+
+	clock.Advance(10 * time.Minute)
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(3), atomic.LoadInt32(requests))
+	for _, list := range lists {
+		members, _ = store.Range(list)
+		assert.Len(members, 0)
+	}
+
+	// 延迟发送
+	delayed, delayedRequests := testReceiver(http.StatusOK)
+	defer delayed.Close()
+	msg = testMessage(11, `{"id":2}`, MessageMeta{Url: delayed.URL, Delay: 60})
+	source.Add(msg)
+	assert.Nil(Fire(context.Background(), store, msg, "", MessageRetry{}))
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(0), atomic.LoadInt32(delayedRequests))
+
+	clock.Advance(time.Minute)
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	assert.Equal(int32(1), atomic.LoadInt32(delayedRequests))
+	scheduled, _ := store.Scheduled("mytopic-zset-delayed")
+	assert.Len(scheduled, 0)
+}
+
+func TestRetrierClosing(t *testing.T) {
+	assert := assert.New(t)
+
+	clock, restore := useFakeClock(time.Unix(1500000000, 0))
+	defer restore()
+	store := NewMemoryStore()
+	retrier := NewRetrier("mytopic", store, NewMemorySource())
+
+	retryData := MessageRetry{Offset: 10, Partition: 1}
+	assert.Nil(gotoRetry(store, "mytopic", retryData, RetryLists("mytopic")[0]))
+	clock.Advance(time.Hour)
+
+	// 退出时不再取出
+	closing := make(chan struct{})
+	close(closing)
+	retrier.Poll(context.Background(), closing)
+	retrier.Wait()
+	members, _ := store.Range(RetryLists("mytopic")[0])
+	assert.Equal([]string{"1:10:0"}, members)
+
+	// 原消息不存在时不发送, 已从重试列表中取出
+	retrier.Poll(context.Background(), nil)
+	retrier.Wait()
+	members, _ = store.Range(RetryLists("mytopic")[0])
+	assert.Len(members, 0)
+}
@@ -6,12 +6,13 @@ import (
 	notification ".."
 	config "../config"
 
-	"errors"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
 	"time"
 
@@ -24,7 +25,15 @@ var (
 	brokers     = flag.String("brokers", os.Getenv("KAFKA_PEERS"), "The comma separated list of brokers in the Kafka cluster")
 	topic       = flag.String("topic", "", "REQUIRED: the topic to consume")
 	verbose     = flag.Bool("verbose", false, "Whether to turn on sarama logging")
+	httpAddr    = flag.String("http", "", "The address to serve metrics and admin endpoints on, e.g. :8081, disabled when empty")
+	shutdown    = flag.Duration("shutdown-timeout", 10*time.Second, "How long to wait for in-flight retries on shutdown before cancelling them")
 	redisClient *redis.Client
+	store       notification.RetryStore
+
+	// 退出时不再取出重试, 等待正在发送的消息, 超过 shutdown-timeout 后取消, 未完成的通知放入重试列表
+	closing             = make(chan struct{})
+	fireCtx, cancelFire = context.WithCancel(context.Background())
+	retrier             *notification.Retrier
 )
 
 func init() {
@@ -51,185 +60,63 @@ func init() {
 	} else {
 		glog.Infof("PING redis output: %s", pong)
 	}
+	store = notification.NewRedisStore(redisClient)
+
+	if err := config.MyConfig.Apply("notification-retry", strings.Split(*brokers, ",")); err != nil {
+		printErrorAndExit(69, "Invalid config: %s", err)
+	}
 }
 
 func main() {
-	fn := "main"
-
-	var lists []string
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 2, "4m"))
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 3, "10m"))
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 4, "10m"))
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 5, "1h"))
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 6, "2h"))
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 7, "6h"))
-	lists = append(lists, fmt.Sprintf(notification.FORMAT_LIST, *topic, 8, "15h"))
-	listsCount := len(lists)
-	listsLastIndex := listsCount - 1
-
-	for {
-		for i, listKey := range lists {
-			glog.V(10).Infof("@%s, list=%s", fn, listKey)
-			for {
-				var (
-					listRes []string
-					err     error
-					offset  int64
-				)
-				listRes, err = redisClient.LRange(listKey, 0, 0).Result()
-				if err != nil {
-					glog.Errorf("@%s, redisClient.LRange failed, err=%s", fn, err)
-					break
-				}
-				if len(listRes) == 0 {
-					glog.V(10).Infof("@%s, len(listRes) == 0, list=%s", fn, listKey)
-					break
-				}
-
-				offset, err = strconv.ParseInt(listRes[0], 10, 64)
-				if err != nil {
-					glog.Errorf("@%s, strconv.ParseInt failed, err=%s, listRes=%s", fn, err, listRes[0])
-					break
-				}
-				offsetKey := fmt.Sprintf(notification.FORMAT_HASH, *topic, offset)
-
-				var (
-					attempts  int32
-					nextTime  int64
-					partition int32
-				)
-				if attempts, nextTime, partition, err = getRetryData(redisClient, offsetKey); err != nil {
-					glog.Errorf("@%s, getRetryData failed, err=%s, offsetKey=%s", fn, err, offsetKey)
-					break
-				}
-
-				if nextTime > time.Now().Unix() {
-					break
-				}
-
-				var dest string
-				if i < listsLastIndex {
-					dest = lists[i+1]
-				} else {
-					dest = ""
-				}
-
-				go retry(offset, partition, dest, notification.MessageRetry{
-					Offset:    offset,
-					Partition: partition,
-					Attempts:  attempts,
-					NextTime:  nextTime,
-				})
-
-				if popOffset, err := redisClient.LPop(listKey).Result(); err != nil {
-					glog.Errorf("@%s, redisClient.LPop failed, err=%s, key=%s", fn, err, listKey)
-					break
-				} else {
-					if popOffset != listRes[0] {
-						glog.Errorf("@%s, popOffset != listRes[0], key=%s, popOffset=%s, listRes=%+v", fn, listKey, popOffset, listRes)
-						break
-					}
-				}
+	if *httpAddr != "" {
+		http.Handle("/admin/", notification.NewAdminHandler(store, *topic))
+		go func() {
+			if err := http.ListenAndServe(*httpAddr, nil); err != nil {
+				printErrorAndExit(69, "Failed to serve metrics and admin endpoints: %s", err)
 			}
-		}
-		time.Sleep(time.Minute * 1)
+		}()
 	}
-}
 
-func getRetryData(redisClient *redis.Client, offsetKey string) (attempts int32, nextTime int64, partition int32, err error) {
-	fn := "getRetryData"
-
-	var (
-		strAttempts  string
-		strNextTime  string
-		strPartition string
-	)
-	strAttempts, err = redisClient.HGet(offsetKey, "attempts").Result()
-	if err != nil {
-		glog.Errorf("@%s, redisClient.HGet failed, field=%s, key=%s", fn, err, "attempts", offsetKey)
-		return
-	}
-	strNextTime, err = redisClient.HGet(offsetKey, "next_time").Result()
-	if err != nil {
-		glog.Errorf("@%s, redisClient.HGet failed, field=%s, key=%s", fn, err, "next_time", offsetKey)
-		return
-	}
-	strPartition, err = redisClient.HGet(offsetKey, "partition").Result()
-	if err != nil {
-		glog.Errorf("@%s, redisClient.HGet failed, field=%s, key=%s", fn, err, "partition", offsetKey)
-		return
-	}
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Kill, os.Interrupt)
+		<-signals
+		glog.Info("Initiating shutdown of retry...")
+		close(closing)
+	}()
 
-	var tmp int64
-	if tmp, err = strconv.ParseInt(strAttempts, 10, 32); err != nil {
-		glog.Errorf("@%s, strconv.ParseInt failed, err=%s, s=%s", fn, err, strAttempts)
-		return
-	} else {
-		attempts = int32(tmp)
-	}
-	if tmp, err = strconv.ParseInt(strNextTime, 10, 32); err != nil {
-		glog.Errorf("@%s, strconv.ParseInt failed, err=%s, s=%s", fn, err, strNextTime)
-		return
-	} else {
-		nextTime = tmp
-	}
-	if tmp, err = strconv.ParseInt(strPartition, 10, 32); err != nil {
-		glog.Errorf("@%s, strconv.ParseInt failed, err=%s, s=%s", fn, err, strPartition)
-		return
-	} else {
-		partition = int32(tmp)
+	retrier = notification.NewRetrier(*topic, store, notification.NewKafkaSource(strings.Split(*brokers, ",")))
+loop:
+	for {
+		retrier.Poll(fireCtx, closing)
+		select {
+		case <-closing:
+			break loop
+		case <-time.After(time.Minute * 1):
+		}
 	}
 
-	return
+	waitInflight()
+	notification.CloseForwarders()
+	notification.CloseStatusTopic()
+	notification.CloseTracing()
 }
 
-func retry(offset int64, partition int32, dest string, retryData notification.MessageRetry) (err error) {
-	fn := "retry"
-
-	brokerList := strings.Split(*brokers, ",")
-	consumer, err := sarama.NewConsumer(brokerList, nil)
-	if err != nil {
-		glog.Errorf("@%s, sarama.NewConsumer failed, err=%s, brokerList=%+v", fn, err, brokerList)
-		return
-	}
-	defer func() {
-		if err := consumer.Close(); err != nil {
-			glog.Errorf("@%s, Failed to close consumer, err=%s", fn, err)
-			return
-		}
+// 等待正在发送的消息, 超过 shutdown-timeout 后取消发送
+func waitInflight() {
+	done := make(chan struct{})
+	go func() {
+		retrier.Wait()
+		close(done)
 	}()
-
-	var pc sarama.PartitionConsumer
-	pc, err = consumer.ConsumePartition(*topic, partition, offset)
-	if err != nil {
-		glog.Errorf("@%s, consumer.ConsumePartition failed, err=%s, topic=%s, partition=%d, offset=%d", fn, err, *topic, partition, offset)
-		return
-	}
-
-	var (
-		message      *sarama.ConsumerMessage
-		messageValid bool = false
-	)
-
 	select {
-	case val := <-pc.Messages():
-		message = val
-		messageValid = true
-	}
-	pc.AsyncClose()
-
-	if messageValid == false {
-		err = errors.New(fmt.Sprintf("@%s, <-pc.Messages() failed, messageValid == false, offset=%d, partition=%d", fn, offset, partition))
-		glog.Error(err)
-		return
+	case <-done:
+	case <-time.After(*shutdown):
+		glog.Info("Cancelling in-flight retries...")
+		cancelFire()
+		<-done
 	}
-
-	if err = notification.Fire(redisClient, message, dest, retryData); err != nil {
-		glog.Errorf("@%s, notification.Fire failed, err=%s, message=%+v, dest=%s", fn, err, message, dest)
-		return
-	}
-
-	return
+	cancelFire()
 }
 
 func printErrorAndExit(code int, format string, values ...interface{}) {
@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+// 按 partition 和 offset 读取原消息, 重试程序使用
+// 默认从 kafka 读取 (NewKafkaSource), 测试时使用内存实现 (NewMemorySource)
+type MessageSource interface {
+	Message(ctx context.Context, topic string, partition int32, offset int64) (*sarama.ConsumerMessage, error)
+}
+
+type kafkaSource struct {
+	brokers []string
+}
+
+func NewKafkaSource(brokers []string) MessageSource {
+	return kafkaSource{brokers: brokers}
+}
+
+// 每次读取使用新的 consumer, 同一 consumer 不能同时读取同一 partition
+func (s kafkaSource) Message(ctx context.Context, topic string, partition int32, offset int64) (message *sarama.ConsumerMessage, err error) {
+	fn := "kafkaSource.Message"
+
+	// 0.10 以上的版本才有消息时间, delay 和 ttl 需要用到; 0.11 以上的版本才有消息 headers
+	consumerConfig := sarama.NewConfig()
+	consumerConfig.Version = ConsumerVersion()
+
+	consumer, err := sarama.NewConsumer(s.brokers, consumerConfig)
+	if err != nil {
+		glog.Errorf("@%s, sarama.NewConsumer failed, err=%s, brokerList=%+v", fn, err, s.brokers)
+		return
+	}
+	defer func() {
+		if err := consumer.Close(); err != nil {
+			glog.Errorf("@%s, Failed to close consumer, err=%s", fn, err)
+		}
+	}()
+
+	var pc sarama.PartitionConsumer
+	pc, err = consumer.ConsumePartition(topic, partition, offset)
+	if err != nil {
+		glog.Errorf("@%s, consumer.ConsumePartition failed, err=%s, topic=%s, partition=%d, offset=%d", fn, err, topic, partition, offset)
+		return
+	}
+	defer pc.AsyncClose()
+
+	select {
+	case message = <-pc.Messages():
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err == nil && message == nil {
+		err = fmt.Errorf("@%s, <-pc.Messages() failed, offset=%d, partition=%d", fn, offset, partition)
+	}
+	return
+}
+
+// MessageSource 的内存实现, 用于测试和本地调试
+type MemorySource struct {
+	mu       sync.RWMutex
+	messages map[string]*sarama.ConsumerMessage
+}
+
+func NewMemorySource() *MemorySource {
+	return &MemorySource{messages: map[string]*sarama.ConsumerMessage{}}
+}
+
+// 加入消息, 按消息的 topic, partition 和 offset 读取
+func (s *MemorySource) Add(msg *sarama.ConsumerMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[memorySourceKey(msg.Topic, msg.Partition, msg.Offset)] = msg
+}
+
+func (s *MemorySource) Message(ctx context.Context, topic string, partition int32, offset int64) (*sarama.ConsumerMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	msg, ok := s.messages[memorySourceKey(topic, partition, offset)]
+	if !ok {
+		return nil, fmt.Errorf("message not found, topic=%s, partition=%d, offset=%d", topic, partition, offset)
+	}
+	return msg, nil
+}
+
+func memorySourceKey(topic string, partition int32, offset int64) string {
+	return fmt.Sprintf("%s:%d:%d", topic, partition, offset)
+}
@@ -0,0 +1,171 @@
+package notification
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/glog"
+)
+
+// 发送状态事件, 发送到配置的 kafka topic, key 为消息 id, 供上游系统获知通知结果
+// Status 为 OUTCOME_DELIVERED, OUTCOME_RETRY_SCHEDULED, OUTCOME_CAPPED, OUTCOME_EXPIRED, OUTCOME_INVALID, OUTCOME_REJECTED 或 OUTCOME_UNROUTED
+type StatusEvent struct {
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	Topic        string `json:"topic"`
+	Partition    int32  `json:"partition"`
+	Offset       int64  `json:"offset"`
+	Destination  int32  `json:"destination"`
+	Subscription string `json:"subscription,omitempty"`
+	Channel      string `json:"channel,omitempty"`
+	UrlHost      string `json:"url_host,omitempty"`    // 通知地址的 host, 完整的地址可能包含 token 等凭证, 不发送
+	Attempts     int32  `json:"attempts"`              // 已重试次数
+	StatusCode   int    `json:"status_code,omitempty"` // 通道的状态码, 如 http 状态码
+	Response     string `json:"response,omitempty"`    // 截断后的返回内容, 见 ResponseConfig.AuditBytes
+	ProducedAt   int64  `json:"produced_at,omitempty"` // 原消息的 kafka 时间 (毫秒)
+	Timestamp    int64  `json:"timestamp"`             // 事件时间 (毫秒)
+}
+
+const STATUS_QUEUE_SIZE = 1000 // 等待写入 producer 的状态事件数, 超过时丢弃
+
+// 状态事件先放入 queue, 由 forwardStatus 写入 producer, 发送流程不会因 producer 阻塞
+type statusReporter struct {
+	mu      sync.RWMutex
+	topic   string
+	queue   chan *sarama.ProducerMessage
+	done    chan struct{} // 关闭时 forwardStatus 写入剩余的事件后关闭 producer
+	stopped chan struct{} // forwardStatus 退出后关闭
+}
+
+var (
+	statuses      = &statusReporter{}
+	statusDropped = expvar.NewInt("notification_status_dropped") // queue 已满时丢弃的状态事件数
+)
+
+// 设置状态事件的 topic, 由启动程序根据配置设置, topic 为空时不发送
+func SetStatusTopic(brokers []string, topic string) (err error) {
+	if topic == "" {
+		statuses.set(nil, "")
+		return
+	}
+	config := sarama.NewConfig()
+	config.Version = sarama.V0_10_0_0
+	config.Producer.RequiredAcks = sarama.WaitForLocal
+	config.Producer.Return.Errors = true
+	var producer sarama.AsyncProducer
+	if producer, err = sarama.NewAsyncProducer(brokers, config); err != nil {
+		return
+	}
+	statuses.set(producer, topic)
+	return
+}
+
+// 关闭状态事件的 producer, 程序退出前调用, 会等待已提交的事件发送完成
+func CloseStatusTopic() {
+	statuses.set(nil, "")
+}
+
+func (r *statusReporter) set(producer sarama.AsyncProducer, topic string) {
+	fn := "statusReporter.set"
+
+	r.mu.Lock()
+	done, stopped := r.done, r.stopped
+	r.topic = topic
+	r.queue, r.done, r.stopped = nil, nil, nil
+	if producer != nil {
+		r.queue = make(chan *sarama.ProducerMessage, STATUS_QUEUE_SIZE)
+		r.done = make(chan struct{})
+		r.stopped = make(chan struct{})
+		go forwardStatus(producer, r.queue, r.done, r.stopped)
+		go func() {
+			for err := range producer.Errors() {
+				glog.Errorf("@%s, send status event failed, err=%s, topic=%s", fn, err.Err, err.Msg.Topic)
+			}
+		}()
+	}
+	r.mu.Unlock()
+
+	if done != nil {
+		close(done)
+		<-stopped
+	}
+}
+
+// 将 queue 中的状态事件写入 producer, done 关闭后写入剩余的事件并关闭 producer
+func forwardStatus(producer sarama.AsyncProducer, queue chan *sarama.ProducerMessage, done chan struct{}, stopped chan struct{}) {
+	fn := "forwardStatus"
+	defer close(stopped)
+
+	for {
+		select {
+		case m := <-queue:
+			producer.Input() <- m
+		case <-done:
+			for {
+				select {
+				case m := <-queue:
+					producer.Input() <- m
+				default:
+					if err := producer.Close(); err != nil {
+						glog.Errorf("@%s, producer.Close failed, err=%s", fn, err)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// 消息 id, 没有指定 meta.id 时为 topic-partition-offset
+func messageId(msg *sarama.ConsumerMessage, meta MessageMeta) string {
+	if meta.Id != "" {
+		return meta.Id
+	}
+	return fmt.Sprintf("%s-%d-%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// 发送状态事件, 不阻塞发送流程, queue 已满时丢弃并计入 notification_status_dropped
+func reportStatus(msg *sarama.ConsumerMessage, id string, destination Destination, retryData MessageRetry, status string, outcome Outcome) {
+	fn := "reportStatus"
+
+	statuses.mu.RLock()
+	queue, topic := statuses.queue, statuses.topic
+	statuses.mu.RUnlock()
+	if queue == nil {
+		return
+	}
+
+	event := StatusEvent{
+		Id:           id,
+		Status:       status,
+		Topic:        msg.Topic,
+		Partition:    msg.Partition,
+		Offset:       msg.Offset,
+		Destination:  retryData.Destination,
+		Subscription: retryData.Subscription,
+		Channel:      destination.Channel,
+		UrlHost:      urlHost(destination.Url),
+		Attempts:     retryData.Attempts,
+		StatusCode:   outcome.StatusCode,
+		Response:     outcome.Result,
+		Timestamp:    time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if !msg.Timestamp.IsZero() {
+		event.ProducedAt = msg.Timestamp.UnixNano() / int64(time.Millisecond)
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("@%s, json.Marshal failed, err=%s, event=%+v", fn, err, event)
+		return
+	}
+	select {
+	case queue <- &sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(id), Value: sarama.ByteEncoder(value)}:
+	default:
+		statusDropped.Add(1)
+		glog.Warningf("@%s, status queue is full, drop, id=%s, status=%s", fn, id, status)
+	}
+}
@@ -0,0 +1,188 @@
+package notification
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 当前时间, 重试时间, 延迟发送, 过期, 限流和熔断都按该时间计算, 测试时替换为假时钟
+var timeNow = time.Now
+
+// 设置当前时间的来源, 端到端测试时使用假时钟, nil 时使用 time.Now
+func SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	timeNow = now
+}
+
+// 重试数据的存储, 默认使用 redis (NewRedisStore), 测试时使用内存实现 (NewMemoryStore)
+// 包括重试和延迟的消息 (hash), 各级重试列表, 死信列表和顺序发送的 key 列表 (list), 延迟队列 (sorted set),
+// 以及订阅 (hash) 和限流令牌桶, 所有进程共享
+type RetryStore interface {
+	// hash
+	SetFields(key string, fields map[string]interface{}) error
+	GetField(key string, field string) (value string, found bool, err error)
+	GetFields(key string) (fields map[string]string, err error) // key 不存在时为空
+	DeleteFields(key string, fields ...string) (n int64, err error)
+
+	// 任意类型的 key
+	Delete(key string) error
+	ExpireAt(key string, at time.Time) error
+
+	// list
+	Push(key string, member string) (n int64, err error) // 追加到列表末尾, 返回追加后的长度
+	Head(key string) (member string, found bool, err error)
+	Pop(key string) (member string, found bool, err error)
+	Len(key string) (n int64, err error)
+	Range(key string) (members []string, err error)
+	Remove(key string, member string) (n int64, err error)
+
+	// sorted set, score 为发送时间 (Unix 时间戳)
+	Schedule(key string, member string, at int64) error
+	Due(key string, until int64) (members []string, err error) // 发送时间不晚于 until 的成员, 按发送时间排序
+	Scheduled(key string) (entries []ScheduledMember, err error)
+	Unschedule(key string, member string) (removed bool, err error)
+
+	// 令牌桶, 返回需要等待的毫秒数, 0 表示已取得令牌, nowMs 为当前时间 (毫秒)
+	TakeToken(key string, rate float64, burst int, nowMs int64) (waitMs int64, err error)
+}
+
+// 延迟队列中的成员
+type ScheduledMember struct {
+	Member string
+	At     int64
+}
+
+// 令牌桶, 返回需要等待的毫秒数, 0 表示已取得令牌
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000 / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`)
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) RetryStore {
+	return redisStore{client: client}
+}
+
+func (s redisStore) SetFields(key string, fields map[string]interface{}) (err error) {
+	_, err = s.client.HMSet(key, fields).Result()
+	return
+}
+
+func (s redisStore) GetField(key string, field string) (value string, found bool, err error) {
+	value, err = s.client.HGet(key, field).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (s redisStore) GetFields(key string) (fields map[string]string, err error) {
+	return s.client.HGetAll(key).Result()
+}
+
+func (s redisStore) DeleteFields(key string, fields ...string) (n int64, err error) {
+	return s.client.HDel(key, fields...).Result()
+}
+
+func (s redisStore) Delete(key string) (err error) {
+	_, err = s.client.Del(key).Result()
+	return
+}
+
+func (s redisStore) ExpireAt(key string, at time.Time) (err error) {
+	_, err = s.client.ExpireAt(key, at).Result()
+	return
+}
+
+func (s redisStore) Push(key string, member string) (n int64, err error) {
+	return s.client.RPush(key, member).Result()
+}
+
+func (s redisStore) Head(key string) (member string, found bool, err error) {
+	member, err = s.client.LIndex(key, 0).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return member, err == nil, err
+}
+
+func (s redisStore) Pop(key string) (member string, found bool, err error) {
+	member, err = s.client.LPop(key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return member, err == nil, err
+}
+
+func (s redisStore) Len(key string) (n int64, err error) {
+	return s.client.LLen(key).Result()
+}
+
+func (s redisStore) Range(key string) (members []string, err error) {
+	return s.client.LRange(key, 0, -1).Result()
+}
+
+func (s redisStore) Remove(key string, member string) (n int64, err error) {
+	return s.client.LRem(key, 0, member).Result()
+}
+
+func (s redisStore) Schedule(key string, member string, at int64) (err error) {
+	_, err = s.client.ZAdd(key, redis.Z{Score: float64(at), Member: member}).Result()
+	return
+}
+
+func (s redisStore) Due(key string, until int64) (members []string, err error) {
+	return s.client.ZRangeByScore(key, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(until, 10),
+	}).Result()
+}
+
+func (s redisStore) Scheduled(key string) (entries []ScheduledMember, err error) {
+	var members []redis.Z
+	if members, err = s.client.ZRangeWithScores(key, 0, -1).Result(); err != nil {
+		return
+	}
+	entries = make([]ScheduledMember, 0, len(members))
+	for _, member := range members {
+		if m, ok := member.Member.(string); ok {
+			entries = append(entries, ScheduledMember{Member: m, At: int64(member.Score)})
+		}
+	}
+	return
+}
+
+func (s redisStore) Unschedule(key string, member string) (removed bool, err error) {
+	var n int64
+	n, err = s.client.ZRem(key, member).Result()
+	return n > 0, err
+}
+
+func (s redisStore) TakeToken(key string, rate float64, burst int, nowMs int64) (waitMs int64, err error) {
+	return tokenBucket.Run(s.client, []string{key}, rate, burst, nowMs).Int64()
+}
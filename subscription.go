@@ -0,0 +1,225 @@
+package notification
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	KEY_SUBSCRIPTIONS = "notification-subscriptions" // 订阅 (redis hash), field 为订阅 id, value 为 json, 所有 topic 共享
+
+	HEADER_TIMESTAMP = "X-Notification-Timestamp" // 签名时间 (Unix 时间戳)
+	HEADER_SIGNATURE = "X-Notification-Signature" // 签名: sha256=hex(hmac_sha256(secret, timestamp + "." + body))
+
+	E_NO_EVENT = "The event of subscription is required"
+)
+
+// 订阅: 事件 (和租户) 对应的通知地址
+// Tenant 为空时订阅所有租户的事件
+type Subscription struct {
+	Id     string `json:"id"`
+	Event  string `json:"event"`
+	Tenant string `json:"tenant"`
+	Destination
+}
+
+// 本进程的订阅缓存, 每 subscriptionTTL 从 RetryStore 重新读取
+var subscriptions = &subscriptionCache{}
+
+const subscriptionTTL = 10 * time.Second
+
+type subscriptionCache struct {
+	mu       sync.Mutex
+	items    []Subscription
+	loadedAt time.Time
+}
+
+// 事件对应的订阅, 按 id 排序, 序号即 MessageRetry.Destination
+func (c *subscriptionCache) resolve(store RetryStore, event string, tenant string) (matched []Subscription, err error) {
+	fn := "subscriptionCache.resolve"
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.items == nil || time.Since(c.loadedAt) >= subscriptionTTL {
+		var items []Subscription
+		if items, err = ListSubscriptions(store); err != nil {
+			glog.Errorf("@%s, ListSubscriptions failed, err=%s", fn, err)
+			return
+		}
+		c.items = items
+		c.loadedAt = time.Now()
+	}
+
+	for _, sub := range c.items {
+		if sub.Event == event && (sub.Tenant == "" || sub.Tenant == tenant) {
+			matched = append(matched, sub)
+		}
+	}
+	return
+}
+
+func (c *subscriptionCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = nil
+}
+
+// 首次发送的通知地址, subscriptionIds 为对应的订阅 id, 不是按事件发送时为空字符串
+func resolveDestinations(store RetryStore, meta MessageMeta) (destinations []Destination, subscriptionIds []string, err error) {
+	if meta.Event == "" {
+		destinations = meta.destinations()
+		subscriptionIds = make([]string, len(destinations))
+		return
+	}
+
+	var subs []Subscription
+	if subs, err = subscriptions.resolve(store, meta.Event, meta.Tenant); err != nil {
+		return
+	}
+	for _, sub := range subs {
+		destinations = append(destinations, sub.Destination)
+		subscriptionIds = append(subscriptionIds, sub.Id)
+	}
+	return
+}
+
+// 重试的通知地址, 订阅或通知地址已不存在时 found 为 false
+func findDestination(store RetryStore, meta MessageMeta, retryData MessageRetry) (destination Destination, found bool, err error) {
+	if retryData.Subscription != "" {
+		var sub Subscription
+		sub, found, err = GetSubscription(store, retryData.Subscription)
+		destination = sub.Destination
+		return
+	}
+
+	destinations := meta.destinations()
+	if retryData.Destination < 0 || int(retryData.Destination) >= len(destinations) {
+		return
+	}
+	return destinations[retryData.Destination], true, nil
+}
+
+// 所有订阅, 按 id 排序
+func ListSubscriptions(store RetryStore) (items []Subscription, err error) {
+	fn := "ListSubscriptions"
+
+	var values map[string]string
+	if values, err = store.GetFields(KEY_SUBSCRIPTIONS); err != nil {
+		glog.Errorf("@%s, store.GetFields failed, err=%s, key=%s", fn, err, KEY_SUBSCRIPTIONS)
+		return
+	}
+	items = make([]Subscription, 0, len(values))
+	for id, value := range values {
+		var sub Subscription
+		if e := json.Unmarshal([]byte(value), &sub); e != nil {
+			glog.Errorf("@%s, json.Unmarshal failed, skip, err=%s, id=%s", fn, e, id)
+			continue
+		}
+		sub.Id = id
+		items = append(items, sub)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id < items[j].Id
+	})
+	return
+}
+
+// 按 id 读取订阅, 重试时使用, 订阅已删除时 found 为 false
+func GetSubscription(store RetryStore, id string) (sub Subscription, found bool, err error) {
+	fn := "GetSubscription"
+
+	value, found, err := store.GetField(KEY_SUBSCRIPTIONS, id)
+	if err != nil {
+		glog.Errorf("@%s, store.GetField failed, err=%s, key=%s, id=%s", fn, err, KEY_SUBSCRIPTIONS, id)
+		return
+	}
+	if !found {
+		return
+	}
+	if err = json.Unmarshal([]byte(value), &sub); err != nil {
+		glog.Errorf("@%s, json.Unmarshal failed, err=%s, id=%s", fn, err, id)
+		found = false
+		return
+	}
+	sub.Id = id
+	return
+}
+
+// 新增或修改订阅, id 为空时生成
+func SaveSubscription(store RetryStore, sub Subscription) (saved Subscription, err error) {
+	fn := "SaveSubscription"
+
+	if err = checkSubscription(sub); err != nil {
+		return
+	}
+	if sub.Id == "" {
+		sub.Id = newSubscriptionId()
+	}
+
+	var value []byte
+	if value, err = json.Marshal(sub); err != nil {
+		glog.Errorf("@%s, json.Marshal failed, err=%s, id=%s, event=%s, tenant=%s", fn, err, sub.Id, sub.Event, sub.Tenant)
+		return
+	}
+	if err = store.SetFields(KEY_SUBSCRIPTIONS, map[string]interface{}{sub.Id: string(value)}); err != nil {
+		glog.Errorf("@%s, store.SetFields failed, err=%s, key=%s, id=%s", fn, err, KEY_SUBSCRIPTIONS, sub.Id)
+		return
+	}
+	subscriptions.invalidate()
+	glog.Infof("@%s, subscription saved, id=%s, event=%s, tenant=%s", fn, sub.Id, sub.Event, sub.Tenant)
+	saved = sub
+	return
+}
+
+// 检查订阅的事件, 以及按发送通道检查通知地址
+func checkSubscription(sub Subscription) (err error) {
+	if sub.Event == "" {
+		return errors.New(E_NO_EVENT)
+	}
+	channel, ok := getChannel(sub.Channel)
+	if !ok {
+		return fmt.Errorf("%s: %s", E_UNKNOWN_CHANNEL, sub.Channel)
+	}
+	return channel.Check(sub.Destination)
+}
+
+// 删除订阅, 已在重试中的通知不再重试
+func DeleteSubscription(store RetryStore, id string) (deleted bool, err error) {
+	fn := "DeleteSubscription"
+
+	var n int64
+	if n, err = store.DeleteFields(KEY_SUBSCRIPTIONS, id); err != nil {
+		glog.Errorf("@%s, store.DeleteFields failed, err=%s, key=%s, id=%s", fn, err, KEY_SUBSCRIPTIONS, id)
+		return
+	}
+	subscriptions.invalidate()
+	deleted = n > 0
+	return
+}
+
+func newSubscriptionId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// 请求签名, 接收方用同一 secret 计算并比较 HEADER_SIGNATURE, 并检查 HEADER_TIMESTAMP 防止重放
+func sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
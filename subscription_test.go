@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionJson(t *testing.T) {
+	assert := assert.New(t)
+
+	var sub Subscription
+	err := json.Unmarshal([]byte(`{"id":"s1","event":"order.paid","tenant":"t1","url":"http://a.com","checker":"status","max_attempts":3,"secret":"k"}`), &sub)
+	assert.Nil(err)
+	assert.Equal(Subscription{
+		Id:     "s1",
+		Event:  "order.paid",
+		Tenant: "t1",
+		Destination: Destination{
+			Url:         "http://a.com",
+			Checker:     CHECKER_STATUS,
+			MaxAttempts: 3,
+			Secret:      "k",
+		},
+	}, sub)
+}
+
+func TestSign(t *testing.T) {
+	assert := assert.New(t)
+
+	// echo -n '1500000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	assert.Equal("sha256=9b122666c0d5c14c39667bf533010c2de24e6f5853f2ec835100c80e98b00e2c", sign("secret", 1500000000, []byte(`{"a":1}`)))
+	assert.NotEqual(sign("secret", 1500000000, []byte(`{"a":1}`)), sign("secret", 1500000001, []byte(`{"a":1}`)))
+}
+
+func TestSaveSubscription(t *testing.T) {
+	assert := assert.New(t)
+
+	store := NewMemoryStore()
+
+	// 按发送通道检查通知地址
+	saved, err := SaveSubscription(store, Subscription{Event: "order.paid", Destination: Destination{Channel: CHANNEL_EMAIL, Url: "mailto:ops@example.com"}})
+	assert.Nil(err)
+	sub, found, err := GetSubscription(store, saved.Id)
+	assert.Nil(err)
+	assert.True(found)
+	assert.Equal(CHANNEL_EMAIL, sub.Channel)
+
+	_, err = SaveSubscription(store, Subscription{Event: "order.paid", Destination: Destination{Channel: CHANNEL_EMAIL, Url: "http://a.com"}})
+	assert.NotNil(err)
+	_, err = SaveSubscription(store, Subscription{Event: "order.paid", Destination: Destination{Channel: "pigeon", Url: "http://a.com"}})
+	assert.EqualError(err, E_UNKNOWN_CHANNEL+": pigeon")
+	_, err = SaveSubscription(store, Subscription{Destination: Destination{Url: "http://a.com"}})
+	assert.EqualError(err, E_NO_EVENT)
+}
+
+func TestFilterSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	items := []Subscription{
+		{Id: "1", Event: "order.paid"},
+		{Id: "2", Event: "order.paid", Tenant: "t1"},
+		{Id: "3", Event: "order.refunded", Tenant: "t1"},
+	}
+	assert.Len(filterSubscriptions(items, "", ""), 3)
+	assert.Len(filterSubscriptions(items, "order.paid", ""), 2)
+	assert.Equal([]Subscription{items[1], items[2]}, filterSubscriptions(items, "", "t1"))
+}
@@ -0,0 +1,115 @@
+package notification
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_CONNECT_TIMEOUT = 10 * time.Second
+	DEFAULT_TLS_TIMEOUT     = 10 * time.Second
+	DEFAULT_TOTAL_TIMEOUT   = 30 * time.Second
+)
+
+// 发送的超时时间 (毫秒), 0 表示使用按 host 配置的或默认的超时时间
+// connect, tls_handshake 和 response_header 只用于 http 通道, total 用于所有通道的一次发送
+type Timeouts struct {
+	Connect        int64 `json:"connect"`         // 建立连接
+	TlsHandshake   int64 `json:"tls_handshake"`   // TLS 握手
+	ResponseHeader int64 `json:"response_header"` // 发送请求后等待返回 header
+	Total          int64 `json:"total"`           // 一次发送的总时间, 包括读取返回内容
+}
+
+// 按 host 或 URL 前缀的超时时间, 最长的前缀优先匹配
+type TimeoutRule struct {
+	Prefix string
+	Timeouts
+}
+
+var timeoutRules = struct {
+	sync.RWMutex
+	rules []TimeoutRule
+}{}
+
+// 设置按 host 的超时时间, 由启动程序根据配置设置
+func SetTimeoutRules(rules []TimeoutRule) {
+	sorted := make([]TimeoutRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Prefix != "" {
+			sorted = append(sorted, rule)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Prefix) > len(sorted[j].Prefix)
+	})
+
+	timeoutRules.Lock()
+	defer timeoutRules.Unlock()
+	timeoutRules.rules = sorted
+}
+
+// 依次使用 meta 中的, 按 host 配置的和默认的超时时间
+func resolveTimeouts(meta Timeouts, url string) (timeouts Timeouts) {
+	timeouts = meta
+	timeoutRules.RLock()
+	for _, rule := range timeoutRules.rules {
+		if matchPrefix(rule.Prefix, url) {
+			timeouts = timeouts.or(rule.Timeouts)
+			break
+		}
+	}
+	timeoutRules.RUnlock()
+	return timeouts.or(Timeouts{
+		Connect:      int64(DEFAULT_CONNECT_TIMEOUT / time.Millisecond),
+		TlsHandshake: int64(DEFAULT_TLS_TIMEOUT / time.Millisecond),
+		Total:        int64(DEFAULT_TOTAL_TIMEOUT / time.Millisecond),
+	})
+}
+
+// 没有指定的超时时间使用 other 中的
+func (t Timeouts) or(other Timeouts) Timeouts {
+	if t.Connect <= 0 {
+		t.Connect = other.Connect
+	}
+	if t.TlsHandshake <= 0 {
+		t.TlsHandshake = other.TlsHandshake
+	}
+	if t.ResponseHeader <= 0 {
+		t.ResponseHeader = other.ResponseHeader
+	}
+	if t.Total <= 0 {
+		t.Total = other.Total
+	}
+	return t
+}
+
+func millis(ms int64) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// 一次发送的 context, 超过 total 后取消
+func (t Timeouts) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.Total <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, millis(t.Total))
+}
+
+func (t Timeouts) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: millis(t.Connect), KeepAlive: 30 * time.Second}
+}
+
+// 等待 d, ctx 取消时提前返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,60 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	SetTimeoutRules([]TimeoutRule{
+		{Prefix: "slow.example.com", Timeouts: Timeouts{Connect: 3000, Total: 60000}},
+		{Prefix: "https://slow.example.com/batch", Timeouts: Timeouts{Total: 120000}},
+	})
+	defer SetTimeoutRules(nil)
+
+	assert.Equal(Timeouts{Connect: 10000, TlsHandshake: 10000, Total: 30000}, resolveTimeouts(Timeouts{}, "https://api.example.com/notify"))
+	assert.Equal(Timeouts{Connect: 3000, TlsHandshake: 10000, Total: 60000}, resolveTimeouts(Timeouts{}, "https://slow.example.com/notify"))
+	assert.Equal(Timeouts{Connect: 10000, TlsHandshake: 10000, Total: 120000}, resolveTimeouts(Timeouts{}, "https://slow.example.com/batch/1"))
+	// meta 中指定的优先
+	assert.Equal(Timeouts{Connect: 3000, TlsHandshake: 10000, ResponseHeader: 500, Total: 5000}, resolveTimeouts(Timeouts{ResponseHeader: 500, Total: 5000}, "https://slow.example.com/notify"))
+}
+
+func TestPostTimeouts(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte("success"))
+	}))
+	defer server.Close()
+
+	start := time.Now()
+	_, _, err := post(context.Background(), `{}`, server.URL, "", "", "", Timeouts{ResponseHeader: 100})
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+
+	start = time.Now()
+	_, _, err = post(context.Background(), `{}`, server.URL, "", "", "", Timeouts{Total: 100})
+	assert.NotNil(err)
+	assert.True(time.Since(start) < time.Second)
+
+	// 取消 ctx 时中止请求
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	outcome := httpChannel{}.Send(ctx, Delivery{Content: `{}`, Destination: Destination{Url: server.URL}})
+	assert.Equal(SEND_RETRY, outcome.Status)
+	assert.NotNil(outcome.Err)
+	assert.True(time.Since(start) < time.Second)
+	assert.False(sleepContext(ctx, time.Minute))
+}
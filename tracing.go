@@ -0,0 +1,219 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	TRACER_NAME = "github.com/PhilipTang/notification"
+
+	TRACING_OTLP   = "otlp"   // OTLP gRPC, 如 otel collector, jaeger
+	TRACING_STDOUT = "stdout" // 输出到标准输出, 用于本地调试
+
+	SPAN_CONSUME  = "notification.consume"
+	SPAN_DECODE   = "notification.decode"
+	SPAN_SEND     = "notification.send"
+	SPAN_SCHEDULE = "notification.schedule"
+
+	// 放入的队列
+	SCHEDULE_RETRY    = "retry"
+	SCHEDULE_DELAY    = "delay"
+	SCHEDULE_THROTTLE = "throttle"
+	SCHEDULE_DEAD     = "dead"
+)
+
+type TracingConfig struct {
+	Exporter    string  // otlp 或 stdout, 为空时不开启
+	Endpoint    string  // otlp 的地址, 如 localhost:4317, 为空时使用 OTEL_EXPORTER_OTLP_ENDPOINT
+	Insecure    bool    // otlp 不使用 TLS
+	ServiceName string  // 如 notification-listener
+	SampleRatio float64 // 采样比例, 0 到 1, 按父 span 的采样结果优先
+}
+
+// trace context 使用 W3C traceparent 和 baggage, 从 kafka 消息 headers 中读取, 写入 http 请求 headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+var tracing = struct {
+	sync.Mutex
+	provider *sdktrace.TracerProvider
+}{}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// 开启链路追踪, 由启动程序根据配置设置
+func SetTracing(config TracingConfig) (err error) {
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case "":
+		return
+	case TRACING_OTLP:
+		var options []otlptracegrpc.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), options...)
+	case TRACING_STDOUT:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unknown tracing exporter: %s", config.Exporter)
+	}
+	if err != nil {
+		return
+	}
+
+	ratio := config.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.ServiceName))),
+	)
+	setTracerProvider(provider)
+	return
+}
+
+func setTracerProvider(provider *sdktrace.TracerProvider) {
+	tracing.Lock()
+	defer tracing.Unlock()
+	tracing.provider = provider
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+}
+
+// 是否开启了链路追踪, 开启时 kafka consumer 需要 0.11 以上的版本才能读取消息 headers
+func TracingEnabled() bool {
+	tracing.Lock()
+	defer tracing.Unlock()
+	return tracing.provider != nil
+}
+
+// 发送未导出的 span 并关闭, 进程退出时调用
+func CloseTracing() {
+	fn := "CloseTracing"
+
+	tracing.Lock()
+	defer tracing.Unlock()
+	if tracing.provider == nil {
+		return
+	}
+	if err := tracing.provider.Shutdown(context.Background()); err != nil {
+		logf(LOG_ERROR, "provider.Shutdown failed", Fields{"fn": fn, "err": err})
+	}
+	tracing.provider = nil
+}
+
+// kafka 消息 headers, 实现 propagation.TextMapCarrier
+type kafkaHeaderCarrier []*sarama.RecordHeader
+
+func (c kafkaHeaderCarrier) Get(key string) string {
+	for _, h := range c {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// 只用于读取
+func (c kafkaHeaderCarrier) Set(key string, value string) {}
+
+func (c kafkaHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, h := range c {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
+
+// 从 kafka 消息 headers 读取上游的 trace context, 开始处理消息的 span
+// 重试程序重新读取原消息, 因此所有重试都在生产者的同一 trace 中
+func startConsumeSpan(ctx context.Context, msg *sarama.ConsumerMessage, retryData MessageRetry) (context.Context, trace.Span) {
+	ctx = propagator.Extract(ctx, kafkaHeaderCarrier(msg.Headers))
+	return tracer().Start(ctx, SPAN_CONSUME,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.Partition))),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+			attribute.Int("notification.attempt", int(retryData.Attempts)),
+			attribute.Bool("notification.retry", retryData != (MessageRetry{})),
+		),
+	)
+}
+
+// 一次发送的 span, ctx 传给发送通道, http 通道将 traceparent 写入请求 headers
+func startSendSpan(ctx context.Context, destination Destination, retryData MessageRetry, try int) (context.Context, trace.Span) {
+	channel := destination.Channel
+	if channel == "" {
+		channel = CHANNEL_HTTP
+	}
+	return tracer().Start(ctx, SPAN_SEND,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("notification.channel", channel),
+			attribute.String("notification.url_host", urlHost(destination.Url)),
+			attribute.Int("notification.destination", int(retryData.Destination)),
+			attribute.Int("notification.attempt", int(retryData.Attempts)),
+			attribute.Int("notification.try", try),
+		),
+	)
+}
+
+func endSendSpan(span trace.Span, outcome Outcome) {
+	span.SetAttributes(
+		attribute.String("notification.status", outcome.Status),
+		attribute.Int("notification.status_code", outcome.StatusCode),
+	)
+	if outcome.Err != nil {
+		span.RecordError(outcome.Err)
+	}
+	if outcome.Status != SEND_DELIVERED {
+		span.SetStatus(codes.Error, outcome.Status)
+	}
+	span.End()
+}
+
+// 放入重试列表, 延迟队列或死信列表的 span
+func startScheduleSpan(ctx context.Context, kind string, retryData MessageRetry, list string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, SPAN_SCHEDULE, trace.WithAttributes(
+		attribute.String("notification.schedule", kind),
+		attribute.String("notification.list", list),
+		attribute.Int("notification.destination", int(retryData.Destination)),
+		attribute.Int("notification.attempt", int(retryData.Attempts)),
+		attribute.Int64("notification.next_time", retryData.NextTime),
+	))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,84 @@
+package notification
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTracing(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	setTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer func() {
+		CloseTracing()
+		otel.SetTracerProvider(noop.NewTracerProvider())
+	}()
+	assert.True(TracingEnabled())
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("success"))
+	}))
+	defer server.Close()
+
+	// 上游生产者的 trace context
+	msg := &sarama.ConsumerMessage{Topic: "mytopic", Partition: 1, Offset: 42, Headers: []*sarama.RecordHeader{
+		{Key: []byte("traceparent"), Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+	}}
+	assert.Equal([]string{"traceparent"}, kafkaHeaderCarrier(msg.Headers).Keys())
+
+	retryData := MessageRetry{Offset: 42, Partition: 1, Attempts: 2}
+	destination := Destination{Url: server.URL}
+	ctx, span := startConsumeSpan(context.Background(), msg, retryData)
+	sendCtx, sendSpan := startSendSpan(ctx, destination, retryData, 1)
+	outcome := httpChannel{}.Send(sendCtx, Delivery{Content: `{"foo":"bar"}`, Destination: destination})
+	endSendSpan(sendSpan, outcome)
+	_, scheduleSpan := startScheduleSpan(ctx, SCHEDULE_RETRY, retryData, "mytopic-list-10m")
+	endSpan(scheduleSpan, nil)
+	endSpan(span, nil)
+
+	assert.Equal(SEND_DELIVERED, outcome.Status)
+	assert.True(strings.HasPrefix(traceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+
+	spans := recorder.Ended()
+	assert.Len(spans, 3)
+	names := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		names[s.Name()] = s
+		assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", s.SpanContext().TraceID().String())
+	}
+	consume, send := names[SPAN_CONSUME], names[SPAN_SEND]
+	assert.Equal("00f067aa0ba902b7", consume.Parent().SpanID().String())
+	assert.Equal(consume.SpanContext().SpanID(), send.Parent().SpanID())
+	assert.Equal(consume.SpanContext().SpanID(), names[SPAN_SCHEDULE].Parent().SpanID())
+	// 请求中的 traceparent 为发送的 span
+	assert.Contains(traceparent, send.SpanContext().SpanID().String())
+}
+
+func TestTracingDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(SetTracing(TracingConfig{}))
+	assert.False(TracingEnabled())
+	assert.NotNil(SetTracing(TracingConfig{Exporter: "zipkin"}))
+
+	// 未开启时不写入 traceparent
+	ctx, span := startConsumeSpan(context.Background(), &sarama.ConsumerMessage{Topic: "mytopic"}, MessageRetry{})
+	defer span.End()
+	header := http.Header{}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+	assert.Empty(header.Get("traceparent"))
+}
@@ -0,0 +1,63 @@
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	E_NO_DESTINATION = "The message has neither event nor url"
+)
+
+// 检查消息能否发送, 生产者发送前调用, 避免无效的消息进入 kafka
+// 按事件发送的消息在发送时才匹配订阅, 只检查 event 不为空
+func (ale *Message) Validate() (err error) {
+	if ale.Meta.Event != "" {
+		return
+	}
+	if ale.Meta.Url == "" && len(ale.Meta.Destinations) == 0 {
+		return errors.New(E_NO_DESTINATION)
+	}
+	for i, destination := range ale.Meta.destinations() {
+		if err = validateDestination(ale.Content, destination); err != nil {
+			if len(ale.Meta.Destinations) > 0 {
+				err = fmt.Errorf("destination %d: %s", i, err)
+			}
+			return
+		}
+	}
+	return
+}
+
+// 检查发送通道, 通知地址, headers, 以及 http 通道下 content 能否按 encoding 编码
+func validateDestination(content string, destination Destination) (err error) {
+	channel, ok := getChannel(destination.Channel)
+	if !ok {
+		return fmt.Errorf("%s: %s", E_UNKNOWN_CHANNEL, destination.Channel)
+	}
+	if err = channel.Check(destination); err != nil {
+		return
+	}
+
+	var headers map[string]string
+	if destination.Headers != "" {
+		if err = json.Unmarshal([]byte(destination.Headers), &headers); err != nil {
+			return fmt.Errorf("invalid headers: %s", err)
+		}
+	}
+	if destination.Channel != "" && destination.Channel != CHANNEL_HTTP {
+		return
+	}
+	var contentType string
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			contentType = v
+		}
+	}
+	if _, _, err = encodeBody(content, destination.Encoding, contentType); err != nil {
+		return fmt.Errorf("invalid content for encoding %q: %s", destination.Encoding, err)
+	}
+	return
+}